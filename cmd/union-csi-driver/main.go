@@ -8,6 +8,8 @@ import (
 	"time"
 
 	flag "github.com/spf13/pflag"
+	v1 "k8s.io/api/core/v1"
+	wait "k8s.io/apimachinery/pkg/util/wait"
 	informers "k8s.io/client-go/informers"
 	kubernetes "k8s.io/client-go/kubernetes"
 	rest "k8s.io/client-go/rest"
@@ -17,6 +19,8 @@ import (
 	driver "github.com/on2e/union-csi-driver/pkg/csi/driver"
 	unionclientset "github.com/on2e/union-csi-driver/pkg/k8s/client/clientset"
 	union "github.com/on2e/union-csi-driver/pkg/union"
+	pod "github.com/on2e/union-csi-driver/pkg/union/pod"
+	webhook "github.com/on2e/union-csi-driver/pkg/webhook"
 )
 
 func main() {
@@ -53,19 +57,54 @@ func main() {
 
 	factory := informers.NewSharedInformerFactory(kubeClient, 15*time.Minute)
 
+	attachPodTemplates := pod.NewTemplates()
+	if options.AttachPodTemplatesFile != "" {
+		attachPodTemplates, err = pod.LoadTemplates(options.AttachPodTemplatesFile)
+		if err != nil {
+			klog.Fatalf("Failed to load attach pod templates: %v", err)
+		}
+	}
+	// Flags take precedence over the default template of the file
+	if options.AttachPodImage != "" {
+		attachPodTemplates.Default.Image = options.AttachPodImage
+	}
+	if options.AttachPodImagePullPolicy != "" {
+		attachPodTemplates.Default.ImagePullPolicy = v1.PullPolicy(options.AttachPodImagePullPolicy)
+	}
+
 	uunion := union.New(
 		kubeClient,
 		unionClient,
 		factory.Core().V1().PersistentVolumeClaims(),
 		factory.Core().V1().Nodes(),
 		factory.Core().V1().Pods(),
+		factory.Storage().V1().CSIStorageCapacities(),
+		union.WithAttachPodTemplates(attachPodTemplates),
+		union.WithStateDir(options.StateDir),
+		union.WithSplitRecovery(options.RecoverVolumeSplits),
+		union.WithOperationTimeout(options.AttachTimeout),
+		union.WithOperationWait(options.AttachWait),
+		union.WithBackoff(wait.Backoff{
+			Duration: options.AttachBackoffDuration,
+			Factor:   options.AttachBackoffFactor,
+			Steps:    options.AttachBackoffSteps,
+		}),
 	)
 
+	// Node plugins only serve node requests, the union lifecycle is up to the controller
+	runUnion := options.Mode != driver.ModeNode
+
 	driver, err := driver.NewDriver(
 		uunion,
 		driver.WithMode(options.Mode),
 		driver.WithCSIEndpoint(options.CSIEndpoint),
 		driver.WithDefaultLowerNamespace(options.DefaultLowerNamespace),
+		driver.WithDaemonSocket(options.DaemonSocket),
+		driver.WithRemountInterval(options.RemountInterval),
+		driver.WithStateDir(options.StateDir),
+		driver.WithGCInterval(options.GCInterval),
+		driver.WithGCDryRun(options.GCDryRun),
+		driver.WithMetricsAddress(options.MetricsAddress),
 	)
 	if err != nil {
 		klog.Fatalf("Failed to create driver: %v", err)
@@ -79,7 +118,21 @@ func main() {
 			klog.Fatalf("Failed to sync caches: %v", k)
 		}
 	}
-	go uunion.Run(ctx)
+	if runUnion {
+		go uunion.Run(ctx)
+	}
+	if runUnion && options.WebhookAddress != "" {
+		server := webhook.NewServer(
+			uunion,
+			webhook.WithAddress(options.WebhookAddress),
+			webhook.WithTLSFiles(options.WebhookCertFile, options.WebhookKeyFile),
+		)
+		go func() {
+			if err := server.Run(ctx); err != nil {
+				klog.Fatalf("Failed to run admission webhook: %v", err)
+			}
+		}()
+	}
 
 	if err := driver.Run(ctx); err != nil {
 		klog.Fatalf("Failed to run driver: %v", err)
@@ -90,7 +143,27 @@ type Options struct {
 	Mode                  driver.DriverMode
 	CSIEndpoint           string
 	DefaultLowerNamespace string
+	DaemonSocket          string
+	RemountInterval       time.Duration
+	StateDir              string
+	GCInterval            time.Duration
+	GCDryRun              bool
+	MetricsAddress        string
+	RecoverVolumeSplits   bool
+	WebhookAddress        string
+	WebhookCertFile       string
+	WebhookKeyFile        string
 	Kubeconfig            string
+
+	AttachPodTemplatesFile   string
+	AttachPodImage           string
+	AttachPodImagePullPolicy string
+
+	AttachTimeout         time.Duration
+	AttachWait            time.Duration
+	AttachBackoffDuration time.Duration
+	AttachBackoffFactor   float64
+	AttachBackoffSteps    int
 }
 
 func GetOptions(fs *flag.FlagSet) *Options {
@@ -112,6 +185,114 @@ func GetOptions(fs *flag.FlagSet) *Options {
 			driver.DefaultLowerNamespace,
 			"Namespace of lower PersistentVolumeClaims when lowerNamespace is unspecified in StorageClass parameters",
 		)
+		fs.StringVar(
+			&options.DaemonSocket,
+			"mergerfs-daemon-socket",
+			"",
+			"Unix domain socket of the gogomergerfs daemon on the node, used by volumes of the daemon attach backend (default gogomergerfs.sock under --state-dir)",
+		)
+		fs.DurationVar(
+			&options.RemountInterval,
+			"remount-interval",
+			driver.DefaultRemountInterval,
+			"How often the node plugin checks staged volumes for broken union mounts and remounts them, 0 disables it",
+		)
+		fs.StringVar(
+			&options.StateDir,
+			"state-dir",
+			union.DefaultStateDir,
+			"Directory on the nodes the driver keeps its state in and attach pods serve union mounts under, must be the same for the controller and node plugins and mounted at the same path in the node plugin",
+		)
+		fs.DurationVar(
+			&options.GCInterval,
+			"gc-interval",
+			driver.DefaultGCInterval,
+			"How often the node plugin sweeps volume directories and union mounts under the state directory of volumes no longer staged on or attached to the node, 0 disables it",
+		)
+		fs.BoolVar(
+			&options.GCDryRun,
+			"gc-dry-run",
+			true,
+			"Only log the orphaned volumes the node plugin finds instead of unmounting and removing them, set to false to remove them",
+		)
+		fs.StringVar(
+			&options.MetricsAddress,
+			"metrics-address",
+			"",
+			"Address to serve metrics at as JSON under /debug/vars, e.g. :8080, empty disables it",
+		)
+		fs.BoolVar(
+			&options.RecoverVolumeSplits,
+			"recover-volume-splits",
+			false,
+			"On startup, recreate the VolumeSplits of volumes whose lower PersistentVolumeClaims exist without one, e.g. after the VolumeSplit CRD was reinstalled, refusing volumes whose lower claims are ambiguous",
+		)
+		fs.StringVar(
+			&options.WebhookAddress,
+			"webhook-address",
+			"",
+			fmt.Sprintf("Address to serve the admission webhook protecting lower PersistentVolumeClaims at, e.g. %s, empty disables it", webhook.DefaultAddress),
+		)
+		fs.StringVar(
+			&options.WebhookCertFile,
+			"webhook-tls-cert-file",
+			"",
+			"Path to the TLS certificate the admission webhook is served with",
+		)
+		fs.StringVar(
+			&options.WebhookKeyFile,
+			"webhook-tls-key-file",
+			"",
+			"Path to the TLS private key the admission webhook is served with",
+		)
+		fs.DurationVar(
+			&options.AttachTimeout,
+			"attach-timeout",
+			union.DefaultOperationTimeout,
+			"How long attaching or detaching a volume at a node may take in the background",
+		)
+		fs.DurationVar(
+			&options.AttachWait,
+			"attach-wait",
+			union.DefaultOperationWait,
+			"How long ControllerPublishVolume and ControllerUnpublishVolume wait for attaching or detaching to complete before returning Unavailable",
+		)
+		fs.DurationVar(
+			&options.AttachBackoffDuration,
+			"attach-backoff-duration",
+			union.DefaultBackoff.Duration,
+			"Initial interval of checking whether attach backends attached or detached a volume",
+		)
+		fs.Float64Var(
+			&options.AttachBackoffFactor,
+			"attach-backoff-factor",
+			union.DefaultBackoff.Factor,
+			"Factor the interval of checking whether attach backends attached or detached a volume grows by",
+		)
+		fs.IntVar(
+			&options.AttachBackoffSteps,
+			"attach-backoff-steps",
+			union.DefaultBackoff.Steps,
+			"Number of checks whether attach backends attached or detached a volume before giving up",
+		)
+		fs.StringVar(
+			&options.AttachPodTemplatesFile,
+			"attach-pod-templates",
+			"",
+			"Path to a YAML file, e.g. mounted from a ConfigMap, with the default attach pod template and named templates that StorageClasses can select with attachPodTemplate",
+		)
+		fs.StringVar(
+			&options.AttachPodImage,
+			"attach-pod-image",
+			"",
+			fmt.Sprintf("Image of attach pods, overrides the default attach pod template (default %q)", pod.DefaultImage),
+		)
+		fs.StringVar(
+			&options.AttachPodImagePullPolicy,
+			"attach-pod-image-pull-policy",
+			"",
+			fmt.Sprintf("Image pull policy of attach pods, overrides the default attach pod template (default %q)", pod.DefaultImagePullPolicy),
+		)
 		//"StorageClass of lower PersistentVolumeClaims when lowerStorageClass is unspecified in StorageClass parameters. If this and lowerStorageClass are both unspecified then any lower PVCs created will have no storageClassName set (default StorageClass)",
 		fs.StringVar(
 			&options.Kubeconfig,
@@ -143,6 +324,13 @@ func GetOptions(fs *flag.FlagSet) *Options {
 		os.Exit(0)
 	}
 
+	switch v1.PullPolicy(options.AttachPodImagePullPolicy) {
+	case "", v1.PullAlways, v1.PullIfNotPresent, v1.PullNever:
+	default:
+		fmt.Printf("unknown attach pod image pull policy: %q. Must be one of %v\n", options.AttachPodImagePullPolicy, []v1.PullPolicy{v1.PullAlways, v1.PullIfNotPresent, v1.PullNever})
+		os.Exit(1)
+	}
+
 	switch *mode {
 	case "all":
 		options.Mode = driver.ModeAll
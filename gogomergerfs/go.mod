@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/spf13/cobra v1.7.0
+	golang.org/x/sys v0.10.0
 	k8s.io/mount-utils v0.28.2
 )
 
@@ -12,7 +13,6 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/moby/sys/mountinfo v0.6.2 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 	k8s.io/klog/v2 v2.100.1 // indirect
 	k8s.io/utils v0.0.0-20230406110748-d93618cff8a2 // indirect
 )
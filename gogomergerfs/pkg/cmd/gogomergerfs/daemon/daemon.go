@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	cobra "github.com/spf13/cobra"
+
+	daemon "github.com/on2e/union-csi-driver/gogomergerfs/pkg/daemon"
+	mergerfs "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger/mergerfs"
+	signal "github.com/on2e/union-csi-driver/gogomergerfs/pkg/signal"
+)
+
+const (
+	// The default unix domain socket, shared with the Union CSI node plugin through the host
+	defaultSocket = "/var/lib/union-csi-driver.union.io/gogomergerfs.sock"
+)
+
+type flags struct {
+	Socket string
+}
+
+func NewCommand() *cobra.Command {
+	flags := &flags{}
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "daemon",
+		Short: "Serve many mergerfs union mounts from one process",
+		Long:  "Serve many mergerfs union mounts from one process, merged and unmerged on request through an HTTP API on a unix domain socket",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(cmd, flags)
+		},
+	}
+	cmd.Flags().StringVar(
+		&flags.Socket,
+		"socket",
+		defaultSocket,
+		"The unix domain socket to listen on",
+	)
+	cmd.Flags().SortFlags = false
+	return cmd
+}
+
+func runCommand(cmd *cobra.Command, flags *flags) error {
+	d := daemon.NewDaemon(mergerfs.NewMergerfs())
+	return d.Serve(signal.SetupSignalHandler(), flags.Socket)
+}
@@ -0,0 +1,51 @@
+package markers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	cobra "github.com/spf13/cobra"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+type flags struct {
+	Branches []string
+}
+
+func NewCommand() *cobra.Command {
+	flags := &flags{}
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "markers",
+		Short: "Print the markers of branches",
+		Long:  "Print the markers of branches as a JSON array in the order of the branches, null for unmarked branches. Branches are only read, unmarked branches are not marked",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(cmd, flags)
+		},
+	}
+	cmd.Flags().StringSliceVar(
+		&flags.Branches,
+		"branches",
+		[]string{},
+		"Comma-separated list of paths to read the markers of",
+	)
+	cmd.Flags().SortFlags = false
+	return cmd
+}
+
+func runCommand(cmd *cobra.Command, flags *flags) error {
+	markers := make([]*merger.BranchMarker, len(flags.Branches))
+	for i, branch := range flags.Branches {
+		marker, err := merger.ReadBranchMarker(branch)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read marker of branch %q: %v", branch, err)
+		}
+		markers[i] = marker
+	}
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(markers)
+}
@@ -1,8 +1,12 @@
 package mergerfs
 
 import (
+	"fmt"
+	"log"
+
 	cobra "github.com/spf13/cobra"
 
+	control "github.com/on2e/union-csi-driver/gogomergerfs/pkg/control"
 	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
 	mergerfs "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger/mergerfs"
 	signal "github.com/on2e/union-csi-driver/gogomergerfs/pkg/signal"
@@ -13,6 +17,11 @@ type flags struct {
 	Target   string
 	Options  []string
 	Block    bool
+	// ControlSocket is the unix domain socket of the control server, used along with Block
+	ControlSocket string
+	// Branch markers
+	VolumeId   string
+	Generation int64
 }
 
 func NewCommand() *cobra.Command {
@@ -45,12 +54,30 @@ func NewCommand() *cobra.Command {
 		[]string{},
 		"Comma-separated list of mount options to pass to mergerfs",
 	)
+	cmd.Flags().StringVar(
+		&flags.VolumeId,
+		"volume-id",
+		"",
+		"The union volume the branches belong to. If set, each branch is checked to be marked as the branch of the volume at its position, and marked so if unmarked, before merging its data directory",
+	)
+	cmd.Flags().Int64Var(
+		&flags.Generation,
+		"generation",
+		0,
+		"The generation of the VolumeSplit of the volume to record in the branch markers, used along with --volume-id",
+	)
 	cmd.Flags().BoolVar(
 		&flags.Block,
 		"block",
 		false,
 		"Execute mergerfs, block for SIGINT | SIGTERM, then unmount. If set to false, execute mergerfs as if executing directly the command",
 	)
+	cmd.Flags().StringVar(
+		&flags.ControlSocket,
+		"control-socket",
+		"",
+		"The unix domain socket to serve an HTTP API on for listing, adding and removing branches and setting their modes while blocking, used along with --block",
+	)
 	cmd.Flags().SortFlags = false
 	return cmd
 }
@@ -58,12 +85,34 @@ func NewCommand() *cobra.Command {
 func runCommand(cmd *cobra.Command, flags *flags) error {
 	var mfs merger.Merger = mergerfs.NewMergerfs()
 
+	if flags.ControlSocket != "" && !flags.Block {
+		return fmt.Errorf("--control-socket can only be used along with --block")
+	}
+
+	if flags.VolumeId != "" {
+		branches, err := merger.VerifyBranchMarkers(flags.Branches, flags.VolumeId, flags.Generation)
+		if err != nil {
+			return err
+		}
+		flags.Branches = branches
+	}
+
 	if !flags.Block {
 		return mfs.Merge(flags.Branches, flags.Target, flags.Options)
 	}
 
+	ctx := signal.SetupSignalHandler()
+
 	bm := merger.NewBlockingMerger(mfs, flags.Branches, flags.Target, flags.Options)
-	if err := bm.Run(signal.SetupSignalHandler()); err != nil {
+	if flags.ControlSocket != "" {
+		srv := control.NewServer(bm, flags.Target)
+		go func() {
+			if err := srv.Serve(ctx, flags.ControlSocket); err != nil {
+				log.Printf("Failed to serve control socket %q: %v", flags.ControlSocket, err)
+			}
+		}()
+	}
+	if err := bm.Run(ctx); err != nil {
 		return err
 	}
 	if err := bm.CleanUp(); err != nil {
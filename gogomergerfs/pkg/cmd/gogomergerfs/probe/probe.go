@@ -0,0 +1,68 @@
+package probe
+
+import (
+	"fmt"
+	"os"
+
+	cobra "github.com/spf13/cobra"
+	mountutils "k8s.io/mount-utils"
+)
+
+const (
+	// The filesystem type of mergerfs mounts as found in /proc/mounts
+	mergerfsFsType = "fuse.mergerfs"
+)
+
+type flags struct {
+	Target string
+}
+
+func NewCommand() *cobra.Command {
+	flags := &flags{}
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "probe",
+		Short: "Check that a mergerfs union mount is live",
+		Long:  "Exit successfully only if target is a mergerfs mount that can be accessed, e.g. to be used as a readiness probe",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCommand(cmd, flags)
+		},
+	}
+	cmd.Flags().StringVar(
+		&flags.Target,
+		"target",
+		"",
+		"The union mount point",
+	)
+	cmd.Flags().SortFlags = false
+	return cmd
+}
+
+func runCommand(cmd *cobra.Command, flags *flags) error {
+	if flags.Target == "" {
+		return fmt.Errorf("target must be set")
+	}
+
+	mps, err := mountutils.New("").List()
+	if err != nil {
+		return err
+	}
+
+	// If target is mounted more than once, the last mount is the one in effect.
+	fsType := ""
+	for i := range mps {
+		if mps[i].Path == flags.Target {
+			fsType = mps[i].Type
+		}
+	}
+	if fsType != mergerfsFsType {
+		return fmt.Errorf("target %q is not a mergerfs mount", flags.Target)
+	}
+
+	// Catch mounts whose mergerfs process is gone
+	if _, err := os.Stat(flags.Target); err != nil {
+		return fmt.Errorf("target %q is not accessible: %v", flags.Target, err)
+	}
+
+	return nil
+}
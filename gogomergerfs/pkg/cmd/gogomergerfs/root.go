@@ -6,7 +6,10 @@ import (
 
 	cobra "github.com/spf13/cobra"
 
+	daemon "github.com/on2e/union-csi-driver/gogomergerfs/pkg/cmd/gogomergerfs/daemon"
+	markers "github.com/on2e/union-csi-driver/gogomergerfs/pkg/cmd/gogomergerfs/markers"
 	mergerfs "github.com/on2e/union-csi-driver/gogomergerfs/pkg/cmd/gogomergerfs/mergerfs"
+	probe "github.com/on2e/union-csi-driver/gogomergerfs/pkg/cmd/gogomergerfs/probe"
 )
 
 func NewCommand() *cobra.Command {
@@ -22,6 +25,9 @@ func NewCommand() *cobra.Command {
 		SilenceUsage:  true,
 	}
 	cmd.AddCommand(mergerfs.NewCommand())
+	cmd.AddCommand(daemon.NewCommand())
+	cmd.AddCommand(probe.NewCommand())
+	cmd.AddCommand(markers.NewCommand())
 	return cmd
 }
 
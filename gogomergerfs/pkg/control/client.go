@@ -0,0 +1,102 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+// Client talks to a control Server over its unix domain socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socket string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// ListBranches returns the branches of the union mount in order.
+func (c *Client) ListBranches(ctx context.Context) ([]merger.Branch, error) {
+	var branches []merger.Branch
+	if err := c.do(ctx, http.MethodGet, branchesPath, nil, &branches); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// AddBranch adds branch to the union mount, after the existing branches or before them if prepend is set.
+func (c *Client) AddBranch(ctx context.Context, branch merger.Branch, prepend bool) error {
+	return c.doBranchRequest(ctx, http.MethodPost, &BranchRequest{Path: branch.Path, Mode: branch.Mode, Prepend: prepend})
+}
+
+// SetBranchMode sets the mode of the branch at path of the union mount.
+func (c *Client) SetBranchMode(ctx context.Context, path string, mode merger.BranchMode) error {
+	return c.doBranchRequest(ctx, http.MethodPatch, &BranchRequest{Path: path, Mode: mode})
+}
+
+// RemoveBranch removes the branch at path from the union mount.
+func (c *Client) RemoveBranch(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodDelete, branchesPath+"?path="+url.QueryEscape(path), nil, nil)
+}
+
+func (c *Client) doBranchRequest(ctx context.Context, method string, req *BranchRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error encoding branch request: %v", err)
+	}
+	return c.do(ctx, method, branchesPath, body, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	// The host is ignored, the transport always dials the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://gogomergerfs"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling gogomergerfs control server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("gogomergerfs control server: %s %s: %s", method, path, strings.TrimSpace(string(msg)))
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			return fmt.Errorf("%w: %v", merger.ErrInvalidBranch, err)
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %v", merger.ErrBranchNotFound, err)
+		case http.StatusConflict:
+			return fmt.Errorf("%w: %v", merger.ErrBranchExists, err)
+		}
+		return err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding gogomergerfs control server response: %v", err)
+		}
+	}
+	return nil
+}
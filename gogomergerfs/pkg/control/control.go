@@ -0,0 +1,145 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+/*
+	The control server changes the branches of a running union mount, e.g. one served with --block.
+	Clients talk to it through an HTTP API over a unix domain socket:
+
+	GET    /branches            list the branches of the union mount
+	POST   /branches            add BranchRequest.Path with BranchRequest.Mode, first if BranchRequest.Prepend
+	PATCH  /branches            set the mode of BranchRequest.Path to BranchRequest.Mode
+	DELETE /branches?path=<p>   remove the branch at p
+*/
+
+const (
+	branchesPath = "/branches"
+)
+
+// BranchRequest describes a branch to add or a branch mode to set.
+type BranchRequest struct {
+	Path    string            `json:"path"`
+	Mode    merger.BranchMode `json:"mode,omitempty"`
+	Prepend bool              `json:"prepend,omitempty"`
+}
+
+// Server serves the control API of the union mount at target.
+type Server struct {
+	// merger is the Merger implementation that serves the union mount
+	merger merger.Merger
+	target string
+
+	// TODO: inject caller's logger, probably implement custom log package
+	logger *log.Logger
+}
+
+func NewServer(m merger.Merger, target string) *Server {
+	return &Server{
+		merger: m,
+		target: target,
+		logger: log.New(os.Stderr, "", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile|log.Lmsgprefix),
+	}
+}
+
+// Serve listens on the unix socket at socket until ctx is cancelled and removes the socket on exit.
+func (s *Server) Serve(ctx context.Context, socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unix domain socket %s: %v", socket, err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(branchesPath, s.handleBranches)
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	s.logger.Printf("Listening for control connections for target %q at %q", s.target, socket)
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleBranches(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		branches, err := s.merger.ListBranches(s.target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, branches)
+		return
+	case http.MethodPost, http.MethodPatch:
+		req := &BranchRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding branch request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" || req.Mode == "" {
+			http.Error(w, "branch path and mode must be set", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = s.merger.AddBranch(s.target, merger.Branch{Path: req.Path, Mode: req.Mode}, req.Prepend)
+		} else {
+			err = s.merger.SetBranchMode(s.target, req.Path, req.Mode)
+		}
+	case http.MethodDelete:
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "branch path must be set", http.StatusBadRequest)
+			return
+		}
+		err = s.merger.RemoveBranch(s.target, path)
+	default:
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, merger.ErrInvalidBranch):
+		return http.StatusBadRequest
+	case errors.Is(err, merger.ErrBranchNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, merger.ErrBranchExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
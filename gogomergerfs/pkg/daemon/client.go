@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to a Daemon over its unix domain socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socket string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Merge asks the daemon to serve the union mount m.
+func (c *Client) Merge(ctx context.Context, m *Merge) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error encoding merge: %v", err)
+	}
+	return c.do(ctx, http.MethodPut, mergesPath+"/"+url.PathEscape(m.Id), body, nil)
+}
+
+// Unmerge asks the daemon to unmerge the union mount of id.
+func (c *Client) Unmerge(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, mergesPath+"/"+url.PathEscape(id), nil, nil)
+}
+
+// List returns the union mounts served by the daemon.
+func (c *Client) List(ctx context.Context) ([]*Merge, error) {
+	var merges []*Merge
+	if err := c.do(ctx, http.MethodGet, mergesPath, nil, &merges); err != nil {
+		return nil, err
+	}
+	return merges, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	// The host is ignored, the transport always dials the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://gogomergerfs"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling gogomergerfs daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("gogomergerfs daemon: %s %s: %s", method, path, strings.TrimSpace(string(msg)))
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		}
+		return err
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding gogomergerfs daemon response: %v", err)
+		}
+	}
+	return nil
+}
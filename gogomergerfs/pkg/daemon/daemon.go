@@ -0,0 +1,250 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	mountutils "k8s.io/mount-utils"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+/*
+	The daemon serves many union mounts from a single process, each keyed by an id (e.g. a volume ID).
+	Clients talk to it through an HTTP API over a unix domain socket:
+
+	GET    /merges       list the union mounts served
+	PUT    /merges/<id>  merge Merge.Branches at Merge.Target
+	DELETE /merges/<id>  unmerge the union mount of id
+*/
+
+const (
+	mergesPath = "/merges"
+)
+
+// Merge describes a union mount served by the daemon.
+type Merge struct {
+	Id       string   `json:"id"`
+	Branches []string `json:"branches"`
+	Target   string   `json:"target"`
+	Options  []string `json:"options,omitempty"`
+	// VolumeId, if set, is the union volume the branches are verified to be marked for before merging
+	// their data directories, along with the Generation of its VolumeSplit. See merger.VerifyBranchMarkers.
+	VolumeId   string `json:"volumeId,omitempty"`
+	Generation int64  `json:"generation,omitempty"`
+}
+
+// Daemon manages the union mounts of many clients.
+type Daemon struct {
+	mu sync.Mutex
+
+	// merger is the Merger implementation to use
+	merger merger.Merger
+	// mounter is used to find union mounts left behind by a previous run
+	mounter mountutils.Interface
+
+	merges map[string]*Merge
+
+	// TODO: inject caller's logger, probably implement custom log package
+	logger *log.Logger
+}
+
+func NewDaemon(m merger.Merger) *Daemon {
+	return &Daemon{
+		merger:  m,
+		mounter: mountutils.New(""),
+		merges:  make(map[string]*Merge),
+		logger:  log.New(os.Stderr, "", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile|log.Lmsgprefix),
+	}
+}
+
+// Serve listens on the unix socket at socket until ctx is cancelled.
+// Union mounts are left in place on exit, it is up to clients to unmerge them.
+func (d *Daemon) Serve(ctx context.Context, socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unix domain socket %s: %v", socket, err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(mergesPath, d.handleList)
+	mux.HandleFunc(mergesPath+"/", d.handleMerge)
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	d.logger.Printf("Listening for connections at %q", socket)
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (d *Daemon) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	d.mu.Lock()
+	merges := make([]*Merge, 0, len(d.merges))
+	for _, m := range d.merges {
+		merges = append(merges, m)
+	}
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, merges)
+}
+
+func (d *Daemon) handleMerge(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, mergesPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, fmt.Sprintf("invalid merge id %q", id), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		m := &Merge{}
+		if err := json.NewDecoder(r.Body).Decode(m); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding merge: %v", err), http.StatusBadRequest)
+			return
+		}
+		m.Id = id
+		if len(m.Branches) == 0 || m.Target == "" {
+			http.Error(w, "merge branches and target must be set", http.StatusBadRequest)
+			return
+		}
+		if err := d.Merge(m); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrConflict) {
+				code = http.StatusConflict
+			} else if errors.Is(err, merger.ErrBranchMismatch) {
+				code = http.StatusPreconditionFailed
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	case http.MethodDelete:
+		if err := d.Unmerge(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// ErrConflict is returned when a merge id or target is already in use by a different union mount.
+var ErrConflict = errors.New("conflicting merge")
+
+// Merge serves the union mount m. It is idempotent for the same m.
+func (d *Daemon) Merge(m *Merge) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if old, ok := d.merges[m.Id]; ok {
+		if !reflect.DeepEqual(old, m) {
+			return fmt.Errorf("%w: %q is already merged with branches %q at target %q", ErrConflict, m.Id, old.Branches, old.Target)
+		}
+		isMnt, err := d.mounter.IsMountPoint(m.Target)
+		if err == nil && isMnt {
+			d.logger.Printf("Branches %q of %q are already merged at target %q", m.Branches, m.Id, m.Target)
+			return nil
+		}
+		// The union mount broke or was unmounted behind our back, e.g. mergerfs crashed, merge again below.
+		d.logger.Printf("Target %q of %q is no longer a healthy mount, merging again", m.Target, m.Id)
+		delete(d.merges, m.Id)
+	}
+
+	for id, old := range d.merges {
+		if old.Target == m.Target {
+			return fmt.Errorf("%w: target %q is already used by %q", ErrConflict, m.Target, id)
+		}
+	}
+
+	// Adopt union mounts served before the daemon restarted.
+	isMnt, err := d.mounter.IsMountPoint(m.Target)
+	if err != nil && !os.IsNotExist(err) {
+		if !mountutils.IsCorruptedMnt(err) {
+			return fmt.Errorf("error checking if target %q is mounted: %v", m.Target, err)
+		}
+		// The mergerfs process of a previous run is gone, e.g. it was killed along with the daemon container.
+		d.logger.Printf("Target %q of %q is corrupted, unmerging before merging again", m.Target, m.Id)
+		if err := d.merger.Unmerge(m.Target); err != nil {
+			return fmt.Errorf("failed to unmerge corrupted target %q: %v", m.Target, err)
+		}
+		isMnt = false
+	}
+	if isMnt {
+		d.logger.Printf("Target %q of %q is already mounted, adopting", m.Target, m.Id)
+		d.merges[m.Id] = m
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Target, 0755); err != nil {
+		return fmt.Errorf("failed to create target %q: %v", m.Target, err)
+	}
+
+	branches := m.Branches
+	if m.VolumeId != "" {
+		var err error
+		if branches, err = merger.VerifyBranchMarkers(m.Branches, m.VolumeId, m.Generation); err != nil {
+			return err
+		}
+	}
+
+	d.logger.Printf("Merging branches %q of %q at target %q ...", branches, m.Id, m.Target)
+	if err := d.merger.Merge(branches, m.Target, m.Options); err != nil {
+		return fmt.Errorf("failed to merge: %v", err)
+	}
+	d.logger.Printf("Merged branches %q of %q at target %q", branches, m.Id, m.Target)
+
+	d.merges[m.Id] = m
+	return nil
+}
+
+// Unmerge undoes Merge for id. It is a no-op if id is unknown.
+func (d *Daemon) Unmerge(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m, ok := d.merges[id]
+	if !ok {
+		d.logger.Printf("%q is not merged", id)
+		return nil
+	}
+
+	d.logger.Printf("Unmerging target %q of %q ...", m.Target, id)
+	if err := d.merger.Unmerge(m.Target); err != nil {
+		return fmt.Errorf("failed to unmerge: %v", err)
+	}
+	d.logger.Printf("Unmerged target %q of %q", m.Target, id)
+
+	delete(d.merges, id)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -50,6 +50,37 @@ func (bm *blockingMerger) Unmerge(target string) error {
 	return nil
 }
 
+func (bm *blockingMerger) ListBranches(target string) ([]Branch, error) {
+	return bm.merger.ListBranches(target)
+}
+
+func (bm *blockingMerger) AddBranch(target string, branch Branch, prepend bool) error {
+	bm.logger.Printf("Adding branch %q (%s) to target %q ...", branch.Path, branch.Mode, target)
+	if err := bm.merger.AddBranch(target, branch, prepend); err != nil {
+		return fmt.Errorf("failed to add branch: %w", err)
+	}
+	bm.logger.Printf("Added branch %q (%s) to target %q", branch.Path, branch.Mode, target)
+	return nil
+}
+
+func (bm *blockingMerger) RemoveBranch(target, path string) error {
+	bm.logger.Printf("Removing branch %q from target %q ...", path, target)
+	if err := bm.merger.RemoveBranch(target, path); err != nil {
+		return fmt.Errorf("failed to remove branch: %w", err)
+	}
+	bm.logger.Printf("Removed branch %q from target %q", path, target)
+	return nil
+}
+
+func (bm *blockingMerger) SetBranchMode(target, path string, mode BranchMode) error {
+	bm.logger.Printf("Setting mode of branch %q of target %q to %s ...", path, target, mode)
+	if err := bm.merger.SetBranchMode(target, path, mode); err != nil {
+		return fmt.Errorf("failed to set branch mode: %w", err)
+	}
+	bm.logger.Printf("Set mode of branch %q of target %q to %s", path, target, mode)
+	return nil
+}
+
 func (bm *blockingMerger) Run(ctx context.Context) error {
 	bm.mu.Lock()
 	if bm.running {
@@ -0,0 +1,161 @@
+package merger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// BranchMarkerName is the file at the root of a branch that marks it as a branch of a union volume.
+const BranchMarkerName = ".union-branch.json"
+
+// BranchDataDirName is the directory of a branch that is merged instead of its root, so that the marker
+// at the root stays out of the union mount.
+const BranchDataDirName = "data"
+
+// BranchMarker is the content of the marker of a branch.
+type BranchMarker struct {
+	// VolumeId is the union volume the branch belongs to
+	VolumeId string `json:"volumeId"`
+	// Index is the position of the branch in the union volume
+	Index int `json:"index"`
+	// Generation is the generation of the VolumeSplit of the volume the branch was last merged with
+	Generation int64 `json:"generation"`
+	// DataDir is the directory of the branch that is merged, relative to its root.
+	// Empty for branches first merged at their root, which keeps their data in place.
+	DataDir string `json:"dataDir,omitempty"`
+}
+
+// ErrBranchMismatch is returned when a branch is marked as a branch of a different volume or at a different index.
+var ErrBranchMismatch = errors.New("branch is marked for a different volume")
+
+// VerifyBranchMarkers checks that each of branches is marked as the branch of volumeId at its index,
+// marking the branches that are not marked yet, e.g. on first merge, and updating the generation of the rest.
+// It returns the paths to merge in place of branches.
+// Foreign or misplaced branches, e.g. a wrong lower volume restored or bound by name, are refused with ErrBranchMismatch
+// before any marker is written.
+// Empty branches are marked to merge their BranchDataDirName. Branches that already hold data when first marked,
+// i.e. merged before markers, keep being merged at their root, marker included.
+func VerifyBranchMarkers(branches []string, volumeId string, generation int64) ([]string, error) {
+	markers := make([]*BranchMarker, len(branches))
+	for i, branch := range branches {
+		marker, err := ReadBranchMarker(branch)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if marker.VolumeId != volumeId {
+			return nil, fmt.Errorf("%w: branch %q is a branch of volume %q, expected %q", ErrBranchMismatch, branch, marker.VolumeId, volumeId)
+		}
+		if marker.Index != i {
+			return nil, fmt.Errorf("%w: branch %q is branch %d of volume %q, expected %d", ErrBranchMismatch, branch, marker.Index, volumeId, i)
+		}
+		markers[i] = marker
+	}
+
+	paths := make([]string, len(branches))
+	for i, branch := range branches {
+		marker := markers[i]
+		if marker == nil {
+			empty, err := isEmptyBranch(branch)
+			if err != nil {
+				return nil, err
+			}
+			marker = &BranchMarker{VolumeId: volumeId, Index: i}
+			if empty {
+				marker.DataDir = BranchDataDirName
+			}
+		}
+		if marker.DataDir != "" {
+			if err := makeDataDir(branch, marker.DataDir); err != nil {
+				return nil, err
+			}
+		}
+		// Written after the data directory, so a marked branch always has it
+		if markers[i] == nil || marker.Generation != generation {
+			marker.Generation = generation
+			if err := writeBranchMarker(branch, marker); err != nil {
+				return nil, err
+			}
+		}
+		paths[i] = filepath.Join(branch, marker.DataDir)
+	}
+	return paths, nil
+}
+
+// isEmptyBranch checks if branch holds no data, lost+found of a new filesystem and a data directory
+// created before the marker could be written aside.
+func isEmptyBranch(branch string) (bool, error) {
+	entries, err := os.ReadDir(branch)
+	if err != nil {
+		return false, fmt.Errorf("failed to read branch %q: %v", branch, err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != "lost+found" && name != BranchDataDirName {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// makeDataDir creates the data directory dataDir of branch with the mode and owner of the root of branch,
+// which the union mount would otherwise have at its root.
+func makeDataDir(branch, dataDir string) error {
+	info, err := os.Stat(branch)
+	if err != nil {
+		return fmt.Errorf("failed to stat branch %q: %v", branch, err)
+	}
+	path := filepath.Join(branch, dataDir)
+	if err := os.Mkdir(path, info.Mode().Perm()); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to create data directory %q: %v", path, err)
+	}
+	// Mkdir applies the umask, set the mode of the root as is
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to set mode of data directory %q: %v", path, err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+			return fmt.Errorf("failed to set owner of data directory %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// ReadBranchMarker reads the marker of branch. The error satisfies os.IsNotExist if branch is not marked.
+func ReadBranchMarker(branch string) (*BranchMarker, error) {
+	path := filepath.Join(branch, BranchMarkerName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	marker := &BranchMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		return nil, fmt.Errorf("error decoding branch marker %q: %v", path, err)
+	}
+	return marker, nil
+}
+
+// writeBranchMarker writes marker at the root of branch, replacing any previous marker atomically.
+func writeBranchMarker(branch string, marker *BranchMarker) error {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("error encoding branch marker: %v", err)
+	}
+	path := filepath.Join(branch, BranchMarkerName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write branch marker %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write branch marker %q: %v", path, err)
+	}
+	return nil
+}
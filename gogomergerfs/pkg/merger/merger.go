@@ -2,6 +2,7 @@ package merger
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"sync"
@@ -13,8 +14,46 @@ type Merger interface {
 	Merge(branches []string, target string, options []string) error
 	// Unmerge undoes the result of Merge, e.g. unmounts the union mount from target.
 	Unmerge(target string) error
+	// ListBranches returns the branches of the union mount at target in order.
+	ListBranches(target string) ([]Branch, error)
+	// AddBranch adds branch to the union mount at target, after the existing branches or before them if prepend is set.
+	AddBranch(target string, branch Branch, prepend bool) error
+	// RemoveBranch removes the branch at path from the union mount at target.
+	RemoveBranch(target, path string) error
+	// SetBranchMode sets the mode of the branch at path of the union mount at target.
+	SetBranchMode(target, path string, mode BranchMode) error
 }
 
+// BranchMode is the mode of a branch of a union mount.
+type BranchMode string
+
+const (
+	// BranchModeRW branches are read and written
+	BranchModeRW BranchMode = "RW"
+	// BranchModeRO branches are only read
+	BranchModeRO BranchMode = "RO"
+	// BranchModeNC branches are read and written but new files are not created on them, e.g. to drain them
+	BranchModeNC BranchMode = "NC"
+)
+
+// IsValid checks if mode is a known BranchMode.
+func (mode BranchMode) IsValid() bool {
+	return mode == BranchModeRW || mode == BranchModeRO || mode == BranchModeNC
+}
+
+// Branch is a branch of a union mount.
+type Branch struct {
+	Path string     `json:"path"`
+	Mode BranchMode `json:"mode"`
+}
+
+// Errors of changing the branches of a union mount
+var (
+	ErrBranchNotFound = errors.New("branch not found")
+	ErrBranchExists   = errors.New("branch already exists")
+	ErrInvalidBranch  = errors.New("invalid branch")
+)
+
 // BlockingMerger is a Merger that can be used to block after a successful Merge
 // and perform a clean up on the union mount when stopped.
 type BlockingMerger interface {
@@ -0,0 +1,210 @@
+package mergerfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	unix "golang.org/x/sys/unix"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+/*
+	mergerfs can be reconfigured at runtime through xattrs on the control file at the root of its mount.
+	See: https://github.com/trapexit/mergerfs/tree/2.37.1#runtime-config
+
+	Writing the branches xattr replaces the branches, or changes them if prefixed:
+
+	+<  /c=RW   prepend a branch
+	+>  /c=RW   append a branch
+	-   /c      remove a branch
+*/
+
+const (
+	// The mergerfs runtime control file found at the root of every mergerfs mount
+	controlFile = ".mergerfs"
+	// The control file xattr that holds the branches of the union mount, e.g. /a=RW:/b=RO
+	branchesXattr = "user.mergerfs.branches"
+)
+
+// branchEntry is a branch as found in the branches xattr, e.g. /a=RW,1G.
+type branchEntry struct {
+	path string
+	mode merger.BranchMode
+	// options are the per branch options following the mode, e.g. minfreespace
+	options string
+}
+
+func (e *branchEntry) String() string {
+	s := e.path + "=" + string(e.mode)
+	if e.options != "" {
+		s += "," + e.options
+	}
+	return s
+}
+
+func (m *mergerfs) ListBranches(target string) ([]merger.Branch, error) {
+	entries, err := getBranchEntries(target)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]merger.Branch, len(entries))
+	for i := range entries {
+		branches[i] = merger.Branch{Path: entries[i].path, Mode: entries[i].mode}
+	}
+	return branches, nil
+}
+
+func (m *mergerfs) AddBranch(target string, branch merger.Branch, prepend bool) error {
+	branch, err := validateBranch(branch)
+	if err != nil {
+		return err
+	}
+
+	entries, err := getBranchEntries(target)
+	if err != nil {
+		return err
+	}
+	if findBranchEntry(entries, branch.Path) >= 0 {
+		return fmt.Errorf("%w: %q is already a branch of %q", merger.ErrBranchExists, branch.Path, target)
+	}
+
+	op := "+>"
+	if prepend {
+		op = "+<"
+	}
+	entry := &branchEntry{path: branch.Path, mode: branch.Mode}
+	return setXattr(target, branchesXattr, op+entry.String())
+}
+
+func (m *mergerfs) RemoveBranch(target, path string) error {
+	path = filepath.Clean(path)
+	entries, err := getBranchEntries(target)
+	if err != nil {
+		return err
+	}
+	if findBranchEntry(entries, path) < 0 {
+		return fmt.Errorf("%w: %q is not a branch of %q", merger.ErrBranchNotFound, path, target)
+	}
+	if len(entries) == 1 {
+		return fmt.Errorf("%w: %q is the last branch of %q", merger.ErrInvalidBranch, path, target)
+	}
+	return setXattr(target, branchesXattr, "-"+path)
+}
+
+func (m *mergerfs) SetBranchMode(target, path string, mode merger.BranchMode) error {
+	if !mode.IsValid() {
+		return fmt.Errorf("%w: unknown mode %q", merger.ErrInvalidBranch, mode)
+	}
+
+	path = filepath.Clean(path)
+	entries, err := getBranchEntries(target)
+	if err != nil {
+		return err
+	}
+	i := findBranchEntry(entries, path)
+	if i < 0 {
+		return fmt.Errorf("%w: %q is not a branch of %q", merger.ErrBranchNotFound, path, target)
+	}
+	if entries[i].mode == mode {
+		return nil
+	}
+
+	// There is no prefix to change a single branch, write all of them back.
+	entries[i].mode = mode
+	values := make([]string, len(entries))
+	for i := range entries {
+		values[i] = entries[i].String()
+	}
+	return setXattr(target, branchesXattr, strings.Join(values, ":"))
+}
+
+// RefreshBranches writes the branches of the mergerfs mount at target back unchanged,
+// so that mergerfs re-evaluates them, e.g. after a branch was expanded or remounted.
+func (m *mergerfs) RefreshBranches(target string) error {
+	value, err := getXattr(target, branchesXattr)
+	if err != nil {
+		return err
+	}
+	return setXattr(target, branchesXattr, value)
+}
+
+// validateBranch checks branch and returns it with its path cleaned, the way it is found in the branches xattr.
+func validateBranch(branch merger.Branch) (merger.Branch, error) {
+	if !filepath.IsAbs(branch.Path) {
+		return branch, fmt.Errorf("%w: path %q is not absolute", merger.ErrInvalidBranch, branch.Path)
+	}
+	// The branches xattr has no escaping.
+	if strings.ContainsAny(branch.Path, ":=") {
+		return branch, fmt.Errorf("%w: path %q contains ':' or '='", merger.ErrInvalidBranch, branch.Path)
+	}
+	if !branch.Mode.IsValid() {
+		return branch, fmt.Errorf("%w: unknown mode %q", merger.ErrInvalidBranch, branch.Mode)
+	}
+	branch.Path = filepath.Clean(branch.Path)
+	return branch, nil
+}
+
+// findBranchEntry returns the index of the entry of the cleaned path in entries, -1 if there is none.
+func findBranchEntry(entries []*branchEntry, path string) int {
+	for i := range entries {
+		if entries[i].path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// getBranchEntries reads the branches of the mergerfs mount at target.
+func getBranchEntries(target string) ([]*branchEntry, error) {
+	value, err := getXattr(target, branchesXattr)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*branchEntry
+	for _, branch := range strings.Split(value, ":") {
+		if branch == "" {
+			continue
+		}
+		entry := &branchEntry{path: branch, mode: merger.BranchModeRW}
+		if i := strings.LastIndex(branch, "="); i > 0 {
+			entry.path = branch[:i]
+			mode, options, _ := strings.Cut(branch[i+1:], ",")
+			entry.mode = merger.BranchMode(mode)
+			entry.options = options
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// getXattr reads the value of xattr from the control file of the mergerfs mount at target.
+func getXattr(target, xattr string) (string, error) {
+	path := filepath.Join(target, controlFile)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := unix.Getxattr(path, xattr, buf)
+		if err == nil {
+			return string(buf[:n]), nil
+		}
+		if !errors.Is(err, unix.ERANGE) {
+			return "", fmt.Errorf("error reading %s of %s: %w", xattr, path, err)
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// setXattr writes value to xattr of the control file of the mergerfs mount at target.
+func setXattr(target, xattr, value string) error {
+	path := filepath.Join(target, controlFile)
+
+	if err := unix.Setxattr(path, xattr, []byte(value), 0); err != nil {
+		return fmt.Errorf("error writing %s of %s: %w", xattr, path, err)
+	}
+	return nil
+}
@@ -11,18 +11,37 @@ Supported capabilities for the driver
 // Capabilities for the identity service
 var pluginCapabilities = []csi.PluginCapability_Service_Type{
 	csi.PluginCapability_Service_CONTROLLER_SERVICE,
+	// Volumes fitted to the CSIStorageCapacity of the lower storage class are only accessible from its topology segments
+	csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
 }
 
+// Volume expansion support for the identity service, volumes of the node and daemon attach backends are expanded
+// while in use, volumes of the pod attach backend fail with FailedPrecondition until detached
+var volumeExpansion = csi.PluginCapability_VolumeExpansion_ONLINE
+
 // Capabilities for the controller service
 var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
 	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 	csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 }
 
 // Capabilities for the node service
-var nodeCapabilities = []csi.NodeServiceCapability_RPC_Type{}
+var nodeCapabilities = []csi.NodeServiceCapability_RPC_Type{
+	csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+	csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+	csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+	csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
 
 // Capabilities for the volumes (access modes)
 var volumeCapabilities = []csi.VolumeCapability_AccessMode_Mode{
 	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	// Require a lower driver that supports ReadOnlyMany and ReadWriteMany respectively.
+	csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
 }
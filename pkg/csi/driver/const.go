@@ -1,9 +1,22 @@
 package driver
 
+import (
+	"time"
+)
+
 // Constants for default driver option values
 const (
-	DefaultCSIEndpoint    = "unix:///tmp/csi.sock"
-	DefaultLowerNamespace = "union"
+	DefaultCSIEndpoint     = "unix:///tmp/csi.sock"
+	DefaultLowerNamespace  = "union"
+	DefaultRemountInterval = 30 * time.Second
+	DefaultGCInterval      = 10 * time.Minute
+)
+
+const (
+	// The directory under the state directory the node plugin keeps track of staged volumes in
+	stagedVolumesDirName = "staged"
+	// The socket of the gogomergerfs daemon under the state directory, unless set with WithDaemonSocket
+	daemonSocketName = "gogomergerfs.sock"
 )
 
 const (
@@ -14,17 +27,36 @@ const (
 const (
 	LowerNamespaceParamKey        = "lowernamespace"
 	LowerStorageClassNameParamKey = "lowerstorageclassname"
+	AttachBackendParamKey         = "attachbackend"
+	AttachPodTemplateParamKey     = "attachpodtemplate"
 	PVCNameParamKey               = "csi.storage.k8s.io/pvc/name"
 	PVCNamespaceParamKey          = "csi.storage.k8s.io/pvc/namespace"
 	PVNameParamKey                = "csi.storage.k8s.io/pv/name"
 )
 
 // Contants for topology keys
-/*const (
+const (
+	// NodeIdTopologyKey is the topology key the node plugin reports its node under, kubelet labels the node with it
+	// and the provisioner needs a topology key on every node since the plugin has accessibility constraints
 	NodeIdTopologyKey = "topology.union.io/node-id"
-)*/
+)
+
+// Contants for VolumeContext keys
+const (
+	AttachBackendVolumeContextKey = "attachBackend"
+)
 
 // Contants for PublishContext keys
 const (
-	PathPublishContextKey = "path"
+	PathPublishContextKey    = "path"
+	PodPathPublishContextKey = "podPath"
+	// The state directory of the controller, the host path of the union mount is under
+	StateDirPublishContextKey = "stateDir"
+)
+
+// Messages of Aborted errors for requests that find another one in progress
+const (
+	volumeOperationPendingFmt     = "An operation for volume %s is already in progress"
+	attachmentOperationPendingFmt = "An operation for volume %s at node %s is already in progress"
+	pathOperationPendingFmt       = "An operation for path %s is already in progress"
 )
@@ -19,6 +19,9 @@ type controllerServer struct {
 	union     union.Interface
 	validator csivalidation.ControllerValidator
 	options   *driverOptions
+	// volumeLocks serializes requests for the same volume, and ControllerPublish/Unpublish requests
+	// for the same volume and node only, so a multi-node volume is attached at its nodes in parallel
+	volumeLocks *keyLocks
 }
 
 var _ csi.ControllerServer = &controllerServer{}
@@ -29,9 +32,10 @@ func newControllerServer(union union.Interface, driverOptions *driverOptions) *c
 		panic(err)
 	}
 	return &controllerServer{
-		union:     union,
-		validator: validator,
-		options:   driverOptions,
+		union:       union,
+		validator:   validator,
+		options:     driverOptions,
+		volumeLocks: newKeyLocks(),
 	}
 }
 
@@ -53,6 +57,7 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	options := &union.CreateLowerOptions{
 		LowerNamespace: s.options.defaultLowerNamespace,
+		AttachBackend:  union.AttachBackendPod,
 	}
 
 	if err := parseParameters(req.GetParameters(), options); err != nil {
@@ -71,6 +76,12 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 
 	volumeName := req.GetName()
 
+	// The name of the volume is its ID too
+	if !s.volumeLocks.tryAcquire(volumeName) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationPendingFmt, volumeName)
+	}
+	defer s.volumeLocks.release(volumeName)
+
 	klog.InfoS("CreateVolume: creating", "Name", volumeName)
 	volume, err := s.union.CreateLower(ctx, volumeName, options)
 	if err != nil {
@@ -79,15 +90,28 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		switch {
 		case errors.Is(err, union.ErrIdempotencyIncompatible):
 			code = codes.AlreadyExists
+		case errors.Is(err, union.ErrInsufficientCapacity):
+			code = codes.ResourceExhausted
+		case errors.Is(err, union.ErrInvalidParameter):
+			code = codes.InvalidArgument
 		}
 		return nil, status.Error(code, msg)
 	}
 	klog.InfoS("CreateVolume: created", "Name", volumeName)
 
+	// The volume can only be attached at the nodes its lower claims are accessible from.
+	accessibleTopology, err := makeAccessibleTopology(volume.NodeTopology)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create volume %s: %v", volumeName, err)
+	}
+
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volume.VolumeId,
 			CapacityBytes: volume.CapacityBytes,
+			// Let the node plugin know how to stage the volume.
+			VolumeContext:      map[string]string{AttachBackendVolumeContextKey: string(volume.AttachBackend)},
+			AccessibleTopology: accessibleTopology,
 		},
 	}, nil
 }
@@ -106,7 +130,20 @@ func parseParameters(params map[string]string, options *union.CreateLowerOptions
 				options.LowerStorageClassName = new(string)
 			}
 			*options.LowerStorageClassName = v
-		case PVCNameParamKey, PVCNamespaceParamKey, PVNameParamKey:
+		case AttachBackendParamKey:
+			switch backend := union.AttachBackend(v); backend {
+			case union.AttachBackendPod, union.AttachBackendNode, union.AttachBackendDaemon:
+				options.AttachBackend = backend
+			default:
+				return status.Errorf(codes.InvalidArgument, "unknown %s value: %q. Must be one of %v", k, v, []union.AttachBackend{union.AttachBackendPod, union.AttachBackendNode, union.AttachBackendDaemon})
+			}
+		case AttachPodTemplateParamKey:
+			options.AttachPodTemplate = v
+		case PVCNameParamKey:
+			options.UpperClaimName = v
+		case PVCNamespaceParamKey:
+			options.UpperClaimNamespace = v
+		case PVNameParamKey:
 			// NOOP ATM
 		default:
 			return status.Errorf(codes.InvalidArgument, "unknown parameters key: %q", k)
@@ -133,6 +170,11 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 
 	volumeId := req.GetVolumeId()
 
+	if !s.volumeLocks.tryAcquire(volumeId) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationPendingFmt, volumeId)
+	}
+	defer s.volumeLocks.release(volumeId)
+
 	klog.InfoS("DeleteVolume: deleting", "VolumeId", volumeId)
 	if err := s.union.DeleteLower(ctx, volumeId); err != nil {
 		code := codes.Internal
@@ -159,6 +201,12 @@ func (s *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 	volumeId := req.GetVolumeId()
 	nodeId := req.GetNodeId()
 
+	lockKey := makeAttachmentLockKey(volumeId, nodeId)
+	if !s.volumeLocks.tryAcquire(lockKey) {
+		return nil, status.Errorf(codes.Aborted, attachmentOperationPendingFmt, volumeId, nodeId)
+	}
+	defer s.volumeLocks.release(lockKey)
+
 	klog.InfoS("ControllerPublishVolume: attaching", "VolumeId", volumeId, "NodeId", nodeId)
 	attachment, err := s.union.AttachLower(ctx, volumeId, nodeId)
 	if err != nil {
@@ -172,21 +220,35 @@ func (s *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi
 		//	code = codes.AlreadyExists
 		case errors.Is(err, union.ErrVolumeNotFound), errors.Is(err, union.ErrNodeNotFound):
 			code = codes.NotFound
+		case errors.Is(err, union.ErrAttachPrecondition):
+			code = codes.FailedPrecondition
+		case errors.Is(err, union.ErrAttachResources):
+			code = codes.ResourceExhausted
+		case errors.Is(err, union.ErrAttachUnavailable), errors.Is(err, union.ErrOperationPending):
+			code = codes.Unavailable
+		case errors.Is(err, union.ErrOperationConflict):
+			code = codes.Aborted
 		}
 		return nil, status.Error(code, msg)
 	}
 	klog.InfoS("ControllerPublishVolume: attached", "VolumeId", volumeId, "NodeId", nodeId)
 
-	publishContext := map[string]string{PathPublishContextKey: attachment.HostPath}
+	// Volumes of the node attach backend are merged by the node plugin, there is no host path to pass.
+	publishContext := map[string]string{StateDirPublishContextKey: s.options.stateDir}
+	if attachment.HostPath != "" {
+		publishContext[PathPublishContextKey] = attachment.HostPath
+	} else if attachment.PodPath != "" {
+		publishContext[PodPathPublishContextKey] = attachment.PodPath
+	}
 
 	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
 }
 
 func (s *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	// NOTE: NodeId is not required to be set in ControllerUnpublishVolumeRequest.
-	// Since we currently not support MULTI_NODE_... i think NodeId will be set at all times
-	// and also we cannot do without when not in MULTI_NODE_... .
-	// Require to be set to find out and consider to check if it is not set when MULTI_NODE_... is not supported in validator pkg.
+	// An empty NodeId means detach from all nodes, which for MULTI_NODE_... volumes
+	// would mean finding and deleting every per-node attach pod.
+	// external-attacher always sets it, so require it and detach only from the given node.
 	if err := s.validator.ControllerUnpublishVolumeRequestValidate(req); err != nil {
 		return nil, err
 	}
@@ -197,6 +259,12 @@ func (s *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *c
 		return nil, status.Error(codes.InvalidArgument, "nodeId is missing")
 	}
 
+	lockKey := makeAttachmentLockKey(volumeId, nodeId)
+	if !s.volumeLocks.tryAcquire(lockKey) {
+		return nil, status.Errorf(codes.Aborted, attachmentOperationPendingFmt, volumeId, nodeId)
+	}
+	defer s.volumeLocks.release(lockKey)
+
 	klog.InfoS("ControllerUnpublishVolume: detaching", "VolumeId", volumeId, "NodeId", nodeId)
 	if err := s.union.DetachLower(ctx, volumeId, nodeId); err != nil {
 		code := codes.Internal
@@ -207,6 +275,10 @@ func (s *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *c
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
 		case errors.Is(err, union.ErrVolumeNotFound), errors.Is(err, union.ErrNodeNotFound):
 			code = codes.NotFound
+		case errors.Is(err, union.ErrOperationPending):
+			code = codes.Unavailable
+		case errors.Is(err, union.ErrOperationConflict):
+			code = codes.Aborted
 		}
 		return nil, status.Error(code, msg)
 	}
@@ -249,12 +321,52 @@ func (s *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnaps
 	return nil, status.Error(codes.Unimplemented, "Unimplemented ListSnapshots method")
 }
 
-// Unimplemented.
 func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented ControllerExpandVolume method")
+	if err := s.validator.ControllerExpandVolumeRequestValidate(req); err != nil {
+		return nil, err
+	}
+
+	volumeId := req.GetVolumeId()
+	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+
+	if !s.volumeLocks.tryAcquire(volumeId) {
+		return nil, status.Errorf(codes.Aborted, volumeOperationPendingFmt, volumeId)
+	}
+	defer s.volumeLocks.release(volumeId)
+
+	klog.InfoS("ControllerExpandVolume: expanding", "VolumeId", volumeId, "RequiredBytes", requiredBytes)
+	volume, err := s.union.ExpandLower(ctx, volumeId, requiredBytes)
+	if err != nil {
+		code := codes.Internal
+		msg := fmt.Sprintf("Failed to expand volume %s: %v", volumeId, err)
+		switch {
+		case errors.Is(err, union.ErrVolumeNotFound):
+			code = codes.NotFound
+		case errors.Is(err, union.ErrInsufficientCapacity):
+			code = codes.ResourceExhausted
+		case errors.Is(err, union.ErrExpandPrecondition):
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Error(code, msg)
+	}
+	if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && volume.CapacityBytes > limitBytes {
+		return nil, status.Errorf(codes.OutOfRange, "Volume %s has %d bytes, more than the limit of %d bytes", volumeId, volume.CapacityBytes, limitBytes)
+	}
+	klog.InfoS("ControllerExpandVolume: expanded", "VolumeId", volumeId, "CapacityBytes", volume.CapacityBytes)
+
+	// The node plugin merges the new lower claims into the union mount.
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         volume.CapacityBytes,
+		NodeExpansionRequired: true,
+	}, nil
 }
 
 // Unimplemented.
 func (s *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "Unimplemented ControllerGetVolume method")
 }
+
+// makeAttachmentLockKey returns the key of volumeLocks for requests of volumeId at nodeId.
+func makeAttachmentLockKey(volumeId, nodeId string) string {
+	return volumeId + "@" + nodeId
+}
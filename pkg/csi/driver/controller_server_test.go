@@ -0,0 +1,186 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+// blockingUnion is a union.Interface whose create and delete calls block until unblock is closed.
+type blockingUnion struct {
+	union.Interface
+	// entered receives once per call that got past the volume lock
+	entered chan string
+	unblock chan struct{}
+}
+
+func newBlockingUnion() *blockingUnion {
+	return &blockingUnion{
+		entered: make(chan string, 16),
+		unblock: make(chan struct{}),
+	}
+}
+
+func (u *blockingUnion) CreateLower(ctx context.Context, volumeName string, options *union.CreateLowerOptions) (*union.Volume, error) {
+	u.entered <- "create"
+	<-u.unblock
+	return &union.Volume{VolumeId: volumeName, CapacityBytes: options.CapacityBytes, AttachBackend: options.AttachBackend}, nil
+}
+
+func (u *blockingUnion) DeleteLower(ctx context.Context, volumeId string) error {
+	u.entered <- "delete"
+	<-u.unblock
+	return nil
+}
+
+func (u *blockingUnion) AttachLower(ctx context.Context, volumeId, nodeId string) (*union.VolumeAttachment, error) {
+	u.entered <- "attach " + nodeId
+	<-u.unblock
+	return &union.VolumeAttachment{VolumeId: volumeId, NodeId: nodeId}, nil
+}
+
+func newTestControllerServer(u union.Interface) *controllerServer {
+	return newControllerServer(u, &driverOptions{defaultLowerNamespace: DefaultLowerNamespace})
+}
+
+func makeCreateVolumeRequest(name string) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name: name,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		},
+	}
+}
+
+func TestControllerConcurrentCreateDeleteVolume(t *testing.T) {
+	u := newBlockingUnion()
+	s := newTestControllerServer(u)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var createErr error
+	go func() {
+		defer wg.Done()
+		_, createErr = s.CreateVolume(ctx, makeCreateVolumeRequest("vol-1"))
+	}()
+	// CreateVolume holds the lock of vol-1 from here on
+	<-u.entered
+
+	// Requests for the same volume fail fast with Aborted while CreateVolume is in progress.
+	var aborted sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		aborted.Add(2)
+		go func() {
+			defer aborted.Done()
+			_, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+			if status.Code(err) != codes.Aborted {
+				t.Errorf("DeleteVolume() during CreateVolume got code %v, want %v", status.Code(err), codes.Aborted)
+			}
+		}()
+		go func() {
+			defer aborted.Done()
+			_, err := s.CreateVolume(ctx, makeCreateVolumeRequest("vol-1"))
+			if status.Code(err) != codes.Aborted {
+				t.Errorf("CreateVolume() during CreateVolume got code %v, want %v", status.Code(err), codes.Aborted)
+			}
+		}()
+	}
+	aborted.Wait()
+
+	close(u.unblock)
+	wg.Wait()
+	if createErr != nil {
+		t.Fatalf("CreateVolume() unexpected error: %v", createErr)
+	}
+
+	// The lock is released once CreateVolume returns.
+	if _, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol-1"}); err != nil {
+		t.Errorf("DeleteVolume() after CreateVolume unexpected error: %v", err)
+	}
+}
+
+func TestControllerConcurrentPublishVolumeAtOtherNodes(t *testing.T) {
+	u := newBlockingUnion()
+	s := newTestControllerServer(u)
+	ctx := context.Background()
+
+	makeRequest := func(nodeId string) *csi.ControllerPublishVolumeRequest {
+		return &csi.ControllerPublishVolumeRequest{
+			VolumeId: "vol-1",
+			NodeId:   nodeId,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		}
+	}
+
+	// Requests for the same volume at different nodes do not abort each other.
+	nodes := []string{"node-1", "node-2", "node-3"}
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, nodeId := range nodes {
+		wg.Add(1)
+		go func(i int, nodeId string) {
+			defer wg.Done()
+			_, errs[i] = s.ControllerPublishVolume(ctx, makeRequest(nodeId))
+		}(i, nodeId)
+	}
+	for range nodes {
+		<-u.entered
+	}
+
+	// A second request at a node with one in progress is aborted.
+	_, err := s.ControllerPublishVolume(ctx, makeRequest("node-1"))
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ControllerPublishVolume() at node with pending request returned %v, want code %v", err, codes.Aborted)
+	}
+
+	close(u.unblock)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("ControllerPublishVolume(%s) unexpected error: %v", nodes[i], err)
+		}
+	}
+}
+
+func TestControllerConcurrentRequestsForOtherVolumes(t *testing.T) {
+	u := newBlockingUnion()
+	s := newTestControllerServer(u)
+	ctx := context.Background()
+
+	// Requests for different volumes do not wait for, nor abort, each other.
+	names := []string{"vol-1", "vol-2", "vol-3", "vol-4"}
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			_, errs[i] = s.CreateVolume(ctx, makeCreateVolumeRequest(name))
+		}(i, name)
+	}
+	for range names {
+		<-u.entered
+	}
+	close(u.unblock)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("CreateVolume(%s) unexpected error: %v", names[i], err)
+		}
+	}
+}
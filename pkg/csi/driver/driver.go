@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"path/filepath"
 	"strings"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	protosanitizer "github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
@@ -49,6 +51,18 @@ type driverOptions struct {
 	mode                  DriverMode
 	csiEndpoint           string
 	defaultLowerNamespace string
+	// daemonSocket is the socket of the gogomergerfs daemon, under stateDir if unset
+	daemonSocket string
+	// remountInterval is how often the node plugin checks for broken union mounts, 0 disables it
+	remountInterval time.Duration
+	// stateDir is the state directory of the driver on the nodes
+	stateDir string
+	// gcInterval is how often the node plugin sweeps orphaned volumes, 0 disables it
+	gcInterval time.Duration
+	// gcDryRun only logs orphaned volumes instead of removing them, the default
+	gcDryRun bool
+	// metricsAddress is the address to serve metrics at, empty disables it
+	metricsAddress string
 }
 
 func NewDriver(unionHandler union.Interface, options ...DriverOption) (*Driver, error) {
@@ -56,11 +70,18 @@ func NewDriver(unionHandler union.Interface, options ...DriverOption) (*Driver,
 		mode:                  ModeAll,
 		csiEndpoint:           DefaultCSIEndpoint,
 		defaultLowerNamespace: DefaultLowerNamespace,
+		remountInterval:       DefaultRemountInterval,
+		stateDir:              union.DefaultStateDir,
+		gcInterval:            DefaultGCInterval,
+		gcDryRun:              true,
 	}
 
 	for _, o := range options {
 		o(driverOptions)
 	}
+	if driverOptions.daemonSocket == "" {
+		driverOptions.daemonSocket = filepath.Join(driverOptions.stateDir, daemonSocketName)
+	}
 
 	// validate driver options here...
 
@@ -75,11 +96,11 @@ func NewDriver(unionHandler union.Interface, options ...DriverOption) (*Driver,
 	switch mode := driverOptions.mode; mode {
 	case ModeAll:
 		driver.ControllerServer = newControllerServer(unionHandler, driverOptions)
-		driver.NodeServer = newNodeServer()
+		driver.NodeServer = newNodeServer(unionHandler, driverOptions)
 	case ModeController:
 		driver.ControllerServer = newControllerServer(unionHandler, driverOptions)
 	case ModeNode:
-		driver.NodeServer = newNodeServer()
+		driver.NodeServer = newNodeServer(unionHandler, driverOptions)
 	default:
 		return nil, fmt.Errorf("unknown driver mode: %q", mode)
 	}
@@ -116,6 +137,19 @@ func (d *Driver) Run(ctx context.Context) error {
 		return fmt.Errorf("unknown driver mode: %q", mode)
 	}
 
+	if ns, ok := d.NodeServer.(*nodeServer); ok {
+		if d.options.remountInterval > 0 {
+			go ns.runRemounter(ctx, d.options.remountInterval)
+		}
+		if d.options.gcInterval > 0 {
+			go ns.runGC(ctx, d.options.gcInterval, d.options.gcDryRun)
+		}
+	}
+
+	if d.options.metricsAddress != "" {
+		go serveMetrics(d.options.metricsAddress)
+	}
+
 	klog.Infof("Listening for connections at %q", listener.Addr())
 
 	return d.srv.Serve(listener)
@@ -161,3 +195,39 @@ func WithDefaultLowerNamespace(ns string) DriverOption {
 		o.defaultLowerNamespace = ns
 	}
 }
+
+func WithDaemonSocket(socket string) DriverOption {
+	return func(o *driverOptions) {
+		o.daemonSocket = socket
+	}
+}
+
+func WithRemountInterval(interval time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		o.remountInterval = interval
+	}
+}
+
+func WithStateDir(dir string) DriverOption {
+	return func(o *driverOptions) {
+		o.stateDir = dir
+	}
+}
+
+func WithGCInterval(interval time.Duration) DriverOption {
+	return func(o *driverOptions) {
+		o.gcInterval = interval
+	}
+}
+
+func WithGCDryRun(dryRun bool) DriverOption {
+	return func(o *driverOptions) {
+		o.gcDryRun = dryRun
+	}
+}
+
+func WithMetricsAddress(address string) DriverOption {
+	return func(o *driverOptions) {
+		o.metricsAddress = address
+	}
+}
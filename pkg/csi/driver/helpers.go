@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func parseEndpoint(endpoint string) (scheme string, address string, err error) {
@@ -30,3 +33,42 @@ func parseEndpoint(endpoint string) (scheme string, address string, err error) {
 
 	return scheme, address, nil
 }
+
+// makeAccessibleTopology returns the CSI topology of the nodes nodeTopology selects, nil for any node.
+// Only the label values CSI topology segments can express are supported, i.e. match labels and In expressions,
+// which is all the CSIStorageCapacity objects of the lower storage classes use.
+func makeAccessibleTopology(nodeTopology *metav1.LabelSelector) ([]*csi.Topology, error) {
+	if nodeTopology == nil {
+		return nil, nil
+	}
+
+	segments := []map[string]string{{}}
+	for key, value := range nodeTopology.MatchLabels {
+		segments[0][key] = value
+	}
+	for _, requirement := range nodeTopology.MatchExpressions {
+		if requirement.Operator != metav1.LabelSelectorOpIn {
+			return nil, fmt.Errorf("node topology requirement %s %s cannot be expressed as CSI topology", requirement.Key, requirement.Operator)
+		}
+		var expanded []map[string]string
+		for _, segment := range segments {
+			for _, value := range requirement.Values {
+				if v, ok := segment[requirement.Key]; ok && v != value {
+					continue
+				}
+				s := map[string]string{requirement.Key: value}
+				for k, v := range segment {
+					s[k] = v
+				}
+				expanded = append(expanded, s)
+			}
+		}
+		segments = expanded
+	}
+
+	topologies := make([]*csi.Topology, 0, len(segments))
+	for _, segment := range segments {
+		topologies = append(topologies, &csi.Topology{Segments: segment})
+	}
+	return topologies, nil
+}
@@ -15,7 +15,7 @@ type identityServer struct {
 var _ csi.IdentityServer = &identityServer{}
 
 func newIdentityServer() *identityServer {
-	validator, err := csivalidation.NewIdentityValidator(pluginCapabilities)
+	validator, err := csivalidation.NewIdentityValidator(pluginCapabilities, volumeExpansion)
 	if err != nil {
 		panic(err)
 	}
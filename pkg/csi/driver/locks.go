@@ -0,0 +1,45 @@
+package driver
+
+import (
+	"sync"
+)
+
+// keyLocks keeps the keys, e.g. volume IDs or target paths, that a request is in progress for.
+// Requests that find their key in progress are expected to fail with Aborted instead of waiting,
+// so that the CO retries them once the pending one completes.
+type keyLocks struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		keys: make(map[string]struct{}),
+	}
+}
+
+// tryAcquire acquires all of keys, or none of them if any is already acquired, and reports whether it did.
+func (l *keyLocks) tryAcquire(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := l.keys[key]; ok {
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.keys[key] = struct{}{}
+	}
+	return true
+}
+
+// release releases keys acquired by tryAcquire.
+func (l *keyLocks) release(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.keys, key)
+	}
+}
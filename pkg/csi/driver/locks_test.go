@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestKeyLocksConcurrentTryAcquire(t *testing.T) {
+	locks := newKeyLocks()
+
+	const workers = 64
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if locks.tryAcquire("vol-1") {
+				acquired.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := acquired.Load(); got != 1 {
+		t.Fatalf("tryAcquire() succeeded %d times for the same key, want 1", got)
+	}
+
+	locks.release("vol-1")
+	if !locks.tryAcquire("vol-1") {
+		t.Errorf("tryAcquire() = false after release, want true")
+	}
+}
+
+func TestKeyLocksTryAcquireAllOrNone(t *testing.T) {
+	locks := newKeyLocks()
+
+	if !locks.tryAcquire("staging", "target") {
+		t.Fatalf("tryAcquire(staging, target) = false, want true")
+	}
+	if locks.tryAcquire("other", "target") {
+		t.Fatalf("tryAcquire(other, target) = true with target acquired, want false")
+	}
+	// A failed tryAcquire must not leave any of its keys acquired.
+	if !locks.tryAcquire("other") {
+		t.Errorf("tryAcquire(other) = false after a failed tryAcquire(other, target), want true")
+	}
+}
+
+func TestKeyLocksConcurrentAcquireRelease(t *testing.T) {
+	locks := newKeyLocks()
+
+	// Each key is held by at most one worker at a time, counted by holders.
+	keys := []string{"vol-1", "vol-2", "vol-3"}
+	holders := make([]atomic.Int32, len(keys))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				k := (i + j) % len(keys)
+				if !locks.tryAcquire(keys[k]) {
+					continue
+				}
+				if n := holders[k].Add(1); n != 1 {
+					t.Errorf("key %s is held by %d requests at once", keys[k], n)
+				}
+				holders[k].Add(-1)
+				locks.release(keys[k])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"expvar"
+	"net/http"
+
+	klog "k8s.io/klog/v2"
+)
+
+// Metrics of the node garbage collector, served as JSON at /debug/vars of the metrics address.
+var (
+	gcMetrics = expvar.NewMap("union_csi_driver_node_gc")
+
+	// Sweeps run and sweeps that failed
+	gcSweeps      = new(expvar.Int)
+	gcSweepErrors = new(expvar.Int)
+	// Orphaned volumes found by the last sweep, in dry-run mode too
+	gcOrphanedVolumes = new(expvar.Int)
+	// Mounts unmounted and volume directories removed by all sweeps
+	gcUnmounted   = new(expvar.Int)
+	gcRemovedDirs = new(expvar.Int)
+)
+
+func init() {
+	gcMetrics.Set("sweeps_total", gcSweeps)
+	gcMetrics.Set("sweep_errors_total", gcSweepErrors)
+	gcMetrics.Set("orphaned_volumes", gcOrphanedVolumes)
+	gcMetrics.Set("unmounted_total", gcUnmounted)
+	gcMetrics.Set("removed_dirs_total", gcRemovedDirs)
+}
+
+// serveMetrics serves the metrics of the driver at address until it fails.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	klog.Infof("Serving metrics at %q", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		klog.Errorf("Failed to serve metrics at %q: %v", address, err)
+	}
+}
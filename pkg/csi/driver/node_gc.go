@@ -0,0 +1,230 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+/*
+	Attach pods that are force deleted and nodes that reboot leave volume directories under <state-dir>/volumes
+	and dead union mounts in them behind, nothing unstages or detaches them anymore. The node plugin sweeps them
+	every GC interval:
+
+	1. lists the volume directories under the state directories and the mounts in them (/proc/self/mountinfo)
+	2. lists the volumes in use on the node: staged on it or attached to it according to their VolumeSplit
+	3. checks the rest against their real state, since volumes staged or attached before either was recorded,
+	   or of recovered VolumeSplits, are in use without records: volumes whose attach backend still has
+	   an attach or holder pod at the node, or whose mounts kubelet still holds at a staging or publish path,
+	   are in use too
+	4. unmounts the mounts of the rest of the volumes, deepest first, and removes their directories
+
+	The volumes in use are listed after the directories, so that a volume attached meanwhile, whose attachment is
+	recorded before its attach pod is created, is in use already. In dry-run mode, the default, orphans are only logged.
+*/
+
+// runGC sweeps orphaned volume directories and mounts every interval until ctx is cancelled.
+func (s *nodeServer) runGC(ctx context.Context, interval time.Duration, dryRun bool) {
+	klog.Infof("Sweeping orphaned volume directories and mounts every %v (dry run: %t)", interval, dryRun)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gcSweeps.Add(1)
+			if err := s.sweep(ctx, dryRun); err != nil {
+				gcSweepErrors.Add(1)
+				klog.Errorf("Failed to sweep orphaned volumes: %v", err)
+			}
+		}
+	}
+}
+
+// orphanCandidate is a volume directory found under a state directory and the mounts in it.
+type orphanCandidate struct {
+	volumeId string
+	dir      string
+	mounts   []string
+}
+
+// sweep unmounts and removes the volume directories of the volumes not in use on the node.
+func (s *nodeServer) sweep(ctx context.Context, dryRun bool) error {
+	candidates, err := s.listVolumeDirs()
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		gcOrphanedVolumes.Set(0)
+		return nil
+	}
+
+	inUse, err := s.listVolumesInUse(ctx)
+	if err != nil {
+		return err
+	}
+
+	var orphans []*orphanCandidate
+	var errs []string
+	for _, c := range candidates {
+		if inUse[c.volumeId] {
+			continue
+		}
+		// Never call a volume an orphan on an error checking it
+		held, err := s.isVolumeHeld(ctx, c)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !held {
+			orphans = append(orphans, c)
+		}
+	}
+	gcOrphanedVolumes.Set(int64(len(orphans)))
+
+	for _, orphan := range orphans {
+		if dryRun {
+			klog.InfoS("Found orphaned volume, dry run", "VolumeId", orphan.volumeId, "Dir", orphan.dir, "Mounts", orphan.mounts)
+			continue
+		}
+		klog.InfoS("Removing orphaned volume", "VolumeId", orphan.volumeId, "Dir", orphan.dir, "Mounts", orphan.mounts)
+		if err := s.removeOrphan(orphan); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove orphaned volumes: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// listVolumeDirs returns the volume directories under the state directories of the node plugin and its staged volumes,
+// including those only left as mount points.
+func (s *nodeServer) listVolumeDirs() ([]*orphanCandidate, error) {
+	stateDirs := map[string]bool{s.stateDir: true}
+	for _, volumeId := range s.volumes.list() {
+		if volume, ok := s.getStagedVolume(volumeId); ok && volume.StateDir != "" {
+			stateDirs[volume.StateDir] = true
+		}
+	}
+
+	var candidates []*orphanCandidate
+	for stateDir := range stateDirs {
+		volumesDir := union.MakeVolumesDir(stateDir)
+		byVolumeId := map[string]*orphanCandidate{}
+
+		entries, err := os.ReadDir(volumesDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read volumes directory %s: %v", volumesDir, err)
+		}
+		for _, entry := range entries {
+			byVolumeId[entry.Name()] = &orphanCandidate{volumeId: entry.Name(), dir: filepath.Join(volumesDir, entry.Name())}
+		}
+
+		mounts, err := s.mounter.ListMounts(volumesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list mounts under %s: %v", volumesDir, err)
+		}
+		for _, mount := range mounts {
+			rel, err := filepath.Rel(volumesDir, mount)
+			if err != nil || rel == "." {
+				continue
+			}
+			volumeId := strings.Split(rel, string(filepath.Separator))[0]
+			c, ok := byVolumeId[volumeId]
+			if !ok {
+				c = &orphanCandidate{volumeId: volumeId, dir: filepath.Join(volumesDir, volumeId)}
+				byVolumeId[volumeId] = c
+			}
+			c.mounts = append(c.mounts, mount)
+		}
+
+		for _, c := range byVolumeId {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+// listVolumesInUse returns the IDs of the volumes staged on the node or attached to it.
+func (s *nodeServer) listVolumesInUse(ctx context.Context) (map[string]bool, error) {
+	inUse := map[string]bool{}
+	for _, volumeId := range s.volumes.list() {
+		inUse[volumeId] = true
+	}
+
+	attached, err := s.union.ListAttachedVolumes(ctx, s.nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes attached to node %q: %v", s.nodeId, err)
+	}
+	for _, volumeId := range attached {
+		inUse[volumeId] = true
+	}
+	return inUse, nil
+}
+
+// isVolumeHeld checks if candidate, a volume with neither staging nor attachment records on the node,
+// is in use anyway: its attach backend still has something at the node or kubelet holds one of its mounts.
+func (s *nodeServer) isVolumeHeld(ctx context.Context, candidate *orphanCandidate) (bool, error) {
+	attached, err := s.union.IsAttachedLower(ctx, candidate.volumeId, s.nodeId)
+	if err != nil && !errors.Is(err, union.ErrVolumeNotFound) {
+		return false, fmt.Errorf("failed to check attach backend of volume %q at node %q: %v", candidate.volumeId, s.nodeId, err)
+	}
+	if attached {
+		klog.InfoS("Volume without records is still attached to the node, not an orphan", "VolumeId", candidate.volumeId, "Dir", candidate.dir)
+		return true, nil
+	}
+
+	for _, mount := range candidate.mounts {
+		refs, err := s.mounter.GetMountRefs(mount)
+		if err != nil {
+			return false, fmt.Errorf("failed to check mount references of %s of volume %q: %v", mount, candidate.volumeId, err)
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, kubeletDir+"/") {
+				klog.InfoS("Volume without records is still mounted by kubelet, not an orphan", "VolumeId", candidate.volumeId, "Path", mount, "Ref", ref)
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// removeOrphan unmounts the mounts of orphan and removes its directory once nothing is mounted in it.
+func (s *nodeServer) removeOrphan(orphan *orphanCandidate) error {
+	for _, mount := range orphan.mounts {
+		if err := s.mounter.Unmount(mount); err != nil {
+			return fmt.Errorf("failed to unmount %s of volume %q: %v", mount, orphan.volumeId, err)
+		}
+		gcUnmounted.Add(1)
+		klog.InfoS("Unmounted orphaned mount", "VolumeId", orphan.volumeId, "Path", mount)
+	}
+
+	// Never remove what is still mounted, e.g. mounted again meanwhile
+	mounts, err := s.mounter.ListMounts(orphan.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list mounts under %s: %v", orphan.dir, err)
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("volume %q is still mounted at %v, leaving %s in place", orphan.volumeId, mounts, orphan.dir)
+	}
+
+	if err := os.RemoveAll(orphan.dir); err != nil {
+		return fmt.Errorf("failed to remove %s of volume %q: %v", orphan.dir, orphan.volumeId, err)
+	}
+	gcRemovedDirs.Add(1)
+	klog.InfoS("Removed orphaned volume directory", "VolumeId", orphan.volumeId, "Dir", orphan.dir)
+	return nil
+}
@@ -0,0 +1,293 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	klog "k8s.io/klog/v2"
+
+	daemon "github.com/on2e/union-csi-driver/gogomergerfs/pkg/daemon"
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+	mount "github.com/on2e/union-csi-driver/pkg/mount"
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+/*
+	Volumes of the node and daemon attach backends are merged by the node plugin itself or the gogomergerfs
+	daemon on the node respectively, instead of an attach pod.
+	Next to the staging target kubelet gives us, e.g. /var/lib/kubelet/plugins/kubernetes.io/csi/<driver>/<sha>/globalmount,
+	the lower volumes are laid out as:
+
+	<sha>/branches/branches.json   the attach backend and the lower volumes as returned by the controller, read back at unstage
+	<sha>/branches/<i>/mount       bind mount of the i-th lower volume, its data directory is the i-th branch of the union mount
+
+	Kubelet mounts the lower volumes for the holder pods of their lower claims, through their drivers and with their secrets,
+	at /var/lib/kubelet/pods/<pod-uid>/volumes/kubernetes.io~csi/<pv>/mount, which the node plugin bind-mounts as branches.
+*/
+
+const (
+	branchesDirName  = "branches"
+	branchesFileName = "branches.json"
+	// The root directory of kubelet, mounted at the same path in the node plugin
+	kubeletDir = "/var/lib/kubelet"
+)
+
+// stagedBranches is the content of branches.json.
+type stagedBranches struct {
+	AttachBackend union.AttachBackend `json:"attachBackend"`
+	Branches      []*union.Branch     `json:"branches"`
+	// Generation is the generation of the VolumeSplit, recorded in the markers of the branches
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// stageLowerBranches mounts the lower volumes of volumeId from their holder pods and merges them at stagingTarget.
+func (s *nodeServer) stageLowerBranches(ctx context.Context, volumeId, stagingTarget string, backend union.AttachBackend) error {
+	volume, err := s.union.GetVolume(ctx, volumeId)
+	if err != nil {
+		return err
+	}
+	branches, err := s.union.GetLowerBranches(ctx, volumeId, s.nodeId)
+	if err != nil {
+		return err
+	}
+
+	branchesDir := getBranchesDir(stagingTarget)
+	if err := os.MkdirAll(branchesDir, 0750); err != nil {
+		return fmt.Errorf("failed to create branches directory %s: %v", branchesDir, err)
+	}
+
+	staged := &stagedBranches{AttachBackend: backend, Branches: branches, Generation: volume.Generation}
+	// Persist the branches before mounting any, so a failed stage can still be unstaged.
+	if err := writeBranches(branchesDir, staged); err != nil {
+		return err
+	}
+
+	return s.mergeLowerBranches(ctx, volumeId, stagingTarget, staged)
+}
+
+// remergeLowerBranches merges the lower volumes of volumeId at stagingTarget again after the union mount broke,
+// e.g. the node plugin or the gogomergerfs daemon restarted.
+func (s *nodeServer) remergeLowerBranches(ctx context.Context, volumeId, stagingTarget string) error {
+	branchesDir := getBranchesDir(stagingTarget)
+	staged, err := readBranches(branchesDir)
+	if err != nil {
+		return err
+	}
+
+	// Holder pods may have been recreated since staging, e.g. after an eviction, and kubelet
+	// published the lower volumes again at other paths. Mount the branches from there.
+	branches, err := s.union.GetLowerBranches(ctx, volumeId, s.nodeId)
+	if err != nil {
+		return err
+	}
+	if len(branches) < len(staged.Branches) {
+		return fmt.Errorf("volume %s has %d lower volumes, staged with %d", volumeId, len(branches), len(staged.Branches))
+	}
+	var changed bool
+	for i, branch := range staged.Branches {
+		if branch.ClaimName != branches[i].ClaimName {
+			return fmt.Errorf("lower volume %d of volume %s is of lower claim %q, staged with %q", i, volumeId, branches[i].ClaimName, branch.ClaimName)
+		}
+		if branch.PodUID == branches[i].PodUID {
+			continue
+		}
+		if err := s.unmountBranch(branchesDir, i); err != nil {
+			return err
+		}
+		staged.Branches[i] = branches[i]
+		changed = true
+	}
+	if changed {
+		if err := writeBranches(branchesDir, staged); err != nil {
+			return err
+		}
+	}
+
+	// The daemon cleans up the broken union mount itself.
+	if staged.AttachBackend != union.AttachBackendDaemon {
+		if err := s.mounter.UnmountCorrupted(stagingTarget); err != nil {
+			return err
+		}
+	}
+
+	return s.mergeLowerBranches(ctx, volumeId, stagingTarget, staged)
+}
+
+// mergeLowerBranches mounts the lower volumes of staged, if not already mounted, and merges them at stagingTarget.
+func (s *nodeServer) mergeLowerBranches(ctx context.Context, volumeId, stagingTarget string, staged *stagedBranches) error {
+	branchesDir := getBranchesDir(stagingTarget)
+
+	var branchPaths []string
+	for i, branch := range staged.Branches {
+		targetPath, err := s.mountBranch(branchesDir, i, branch)
+		if err != nil {
+			return err
+		}
+		branchPaths = append(branchPaths, targetPath)
+	}
+
+	// Mark the union mount as the one of volumeId for NodePublishVolume to check
+	options := []string{mount.MakeFsNameOption(volumeId)}
+	if staged.AttachBackend == union.AttachBackendDaemon {
+		return s.daemonClient.Merge(ctx, &daemon.Merge{
+			Id:         volumeId,
+			Branches:   branchPaths,
+			Target:     stagingTarget,
+			Options:    options,
+			VolumeId:   volumeId,
+			Generation: staged.Generation,
+		})
+	}
+	// Never merge lower volumes of another volume or in another order
+	dataPaths, err := merger.VerifyBranchMarkers(branchPaths, volumeId, staged.Generation)
+	if err != nil {
+		return err
+	}
+	return s.mounter.Merge(dataPaths, stagingTarget, options)
+}
+
+// expandLowerBranches mounts the lower volumes of volumeId that were added since it was staged at stagingTarget,
+// e.g. by ControllerExpandVolume, and adds them to the running union mount. Lower volumes are only ever appended,
+// so the branches already merged keep their index.
+func (s *nodeServer) expandLowerBranches(ctx context.Context, volumeId, stagingTarget string) error {
+	branchesDir := getBranchesDir(stagingTarget)
+	staged, err := readBranches(branchesDir)
+	if err != nil {
+		return err
+	}
+	volume, err := s.union.GetVolume(ctx, volumeId)
+	if err != nil {
+		return err
+	}
+	branches, err := s.union.GetLowerBranches(ctx, volumeId, s.nodeId)
+	if err != nil {
+		return err
+	}
+
+	merged := len(staged.Branches)
+	if len(branches) > len(staged.Branches) {
+		staged.Branches = append(staged.Branches, branches[len(staged.Branches):]...)
+		staged.Generation = volume.Generation
+		// Persist the branches before mounting any, so a failed expansion can still be unstaged.
+		if err := writeBranches(branchesDir, staged); err != nil {
+			return err
+		}
+	}
+
+	// The branches already merged are in use by the union mount, only mount the new ones.
+	var branchPaths []string
+	for i, branch := range staged.Branches {
+		if i < merged {
+			branchPaths = append(branchPaths, getBranchPath(branchesDir, i))
+			continue
+		}
+		targetPath, err := s.mountBranch(branchesDir, i, branch)
+		if err != nil {
+			return err
+		}
+		branchPaths = append(branchPaths, targetPath)
+	}
+
+	dataPaths, err := merger.VerifyBranchMarkers(branchPaths, volumeId, staged.Generation)
+	if err != nil {
+		return err
+	}
+	// The branches xattr is set on the union mount itself, whether the node plugin or the daemon serves it.
+	return s.mounter.AddBranches(dataPaths, stagingTarget)
+}
+
+// unstageLowerBranches undoes stageLowerBranches.
+func (s *nodeServer) unstageLowerBranches(ctx context.Context, volumeId, stagingTarget string) error {
+	branchesDir := getBranchesDir(stagingTarget)
+	staged, err := readBranches(branchesDir)
+	if err != nil {
+		return err
+	}
+
+	if staged.AttachBackend == union.AttachBackendDaemon {
+		if err := s.daemonClient.Unmerge(ctx, volumeId); err != nil {
+			return err
+		}
+	}
+	// Also unmounts union mounts the daemon does not know of, e.g. merged before it restarted.
+	if err := s.mounter.Unstage(stagingTarget); err != nil {
+		return err
+	}
+
+	for i := len(staged.Branches) - 1; i >= 0; i-- {
+		if err := s.unmountBranch(branchesDir, i); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(branchesDir); err != nil {
+		return fmt.Errorf("failed to remove branches directory %s: %v", branchesDir, err)
+	}
+	klog.Infof("Removed branches directory %s", branchesDir)
+
+	return nil
+}
+
+// mountBranch bind-mounts the i-th lower volume from the publish path of its holder pod and returns the branch path.
+// A bind mount rather than a symlink, so the union mount fails I/O instead of writing to the node
+// if kubelet ever unmounts the lower volume under it.
+func (s *nodeServer) mountBranch(branchesDir string, i int, branch *union.Branch) (string, error) {
+	targetPath := getBranchPath(branchesDir, i)
+	if err := s.mounter.Stage(getKubeletBranchPath(branch), targetPath); err != nil {
+		return "", fmt.Errorf("failed to mount lower volume %q of holder pod %s: %v", branch.VolumeName, branch.PodUID, err)
+	}
+	return targetPath, nil
+}
+
+// unmountBranch undoes mountBranch.
+func (s *nodeServer) unmountBranch(branchesDir string, i int) error {
+	return s.mounter.Unstage(getBranchPath(branchesDir, i))
+}
+
+// hasLowerBranches checks if stagingTarget was staged by stageLowerBranches.
+func hasLowerBranches(stagingTarget string) bool {
+	_, err := os.Stat(filepath.Join(getBranchesDir(stagingTarget), branchesFileName))
+	return err == nil
+}
+
+func getBranchesDir(stagingTarget string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(stagingTarget)), branchesDirName)
+}
+
+// getKubeletBranchPath returns where kubelet publishes the lower volume of branch for its holder pod.
+func getKubeletBranchPath(branch *union.Branch) string {
+	return filepath.Join(kubeletDir, "pods", branch.PodUID, "volumes", "kubernetes.io~csi", branch.VolumeName, "mount")
+}
+
+func getBranchPath(branchesDir string, i int) string {
+	return filepath.Join(branchesDir, strconv.Itoa(i), "mount")
+}
+
+func writeBranches(branchesDir string, staged *stagedBranches) error {
+	data, err := json.Marshal(staged)
+	if err != nil {
+		return fmt.Errorf("error encoding branches: %v", err)
+	}
+	path := filepath.Join(branchesDir, branchesFileName)
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func readBranches(branchesDir string) (*stagedBranches, error) {
+	path := filepath.Join(branchesDir, branchesFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	staged := &stagedBranches{}
+	if err := json.Unmarshal(data, staged); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", path, err)
+	}
+	return staged, nil
+}
@@ -0,0 +1,156 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	mount "github.com/on2e/union-csi-driver/pkg/mount"
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+/*
+	Union mounts break when the mergerfs process behind them goes away, e.g. the attach pod crashed or was evicted,
+	or the node plugin or the gogomergerfs daemon restarted. Their staging and publish targets are left as dead FUSE
+	endpoints ("transport endpoint is not connected") and kubelet has no reason to stage or publish them again.
+	The node plugin checks the volumes staged on the node every remount interval and:
+
+	1. waits for the union mount to be served again: the controller recreates failed or deleted attach pods,
+	   the node plugin merges the lower volumes again for the node and daemon attach backends
+	2. stages the volume again from the new union mount
+	3. publishes the volume again at its targets and reports the volume condition of remounted targets as abnormal,
+	   since consumers only see the new mount if they receive mounts from the host (HostToContainer propagation)
+
+	A remount takes the path locks of the staging target and the targets of the volume, so it is serialized
+	with the node requests for the volume only, and skipped until the next interval if any of them is in progress.
+*/
+
+// runRemounter remounts broken volumes every interval until ctx is cancelled.
+func (s *nodeServer) runRemounter(ctx context.Context, interval time.Duration) {
+	klog.Infof("Checking staged volumes for broken union mounts every %v", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, volumeId := range s.volumes.list() {
+				if err := s.remountVolume(ctx, volumeId); err != nil {
+					klog.Errorf("Failed to remount volume %q: %v", volumeId, err)
+				}
+			}
+		}
+	}
+}
+
+// remountVolume remounts volumeId if its union mount is broken.
+func (s *nodeServer) remountVolume(ctx context.Context, volumeId string) error {
+	volume, ok := s.getStagedVolume(volumeId)
+	if !ok || !isBroken(volume) {
+		return nil
+	}
+
+	paths := []string{volume.StagingTarget}
+	for target := range volume.Targets {
+		paths = append(paths, target)
+	}
+	if !s.pathLocks.tryAcquire(paths...) {
+		klog.V(4).InfoS("Volume has node operations in progress, remounting next time", "VolumeId", volumeId)
+		return nil
+	}
+	defer s.pathLocks.release(paths...)
+
+	// Unstaged or unpublished meanwhile, targets published meanwhile are left to the next interval
+	volume, ok = s.getStagedVolume(volumeId)
+	if !ok {
+		return nil
+	}
+	klog.InfoS("Remounting broken volume", "VolumeId", volumeId, "StagingTargetPath", volume.StagingTarget, "AttachBackend", volume.AttachBackend)
+
+	if mount.IsCorrupted(volume.StagingTarget) {
+		if err := s.restageVolume(ctx, volume); err != nil {
+			return err
+		}
+		klog.InfoS("Remounted staging target", "VolumeId", volumeId, "StagingTargetPath", volume.StagingTarget)
+	}
+
+	for _, target := range paths[1:] {
+		t, ok := volume.Targets[target]
+		if !ok || !mount.IsCorrupted(target) {
+			continue
+		}
+		if err := s.mounter.Unpublish(target); err != nil {
+			return err
+		}
+		if err := s.mounter.Publish(ctx, volume.StagingTarget, target, t.Options); err != nil {
+			return err
+		}
+		if err := s.volumes.remounted(volumeId, target); err != nil {
+			return err
+		}
+		klog.InfoS("Remounted target", "VolumeId", volumeId, "TargetPath", target)
+	}
+
+	return nil
+}
+
+// restageVolume stages volume again from its new union mount.
+func (s *nodeServer) restageVolume(ctx context.Context, volume *stagedVolume) error {
+	switch volume.AttachBackend {
+	case union.AttachBackendNode, union.AttachBackendDaemon:
+		return s.remergeLowerBranches(ctx, volume.VolumeId, volume.StagingTarget)
+	}
+	if volume.PodPath != "" {
+		return s.mounter.StageFromPod(volume.PodPath, volume.StagingTarget)
+	}
+
+	// Dead mounts left at the host path keep a restarting attach pod from serving it again.
+	if mount.IsCorrupted(volume.HostPath) {
+		if err := s.mounter.UnmountCorrupted(volume.HostPath); err != nil {
+			return err
+		}
+	}
+	isMergerfs, err := s.mounter.IsMergerfsMountPoint(volume.HostPath)
+	if err != nil {
+		return err
+	}
+	if !isMergerfs {
+		return fmt.Errorf("%w: attach pod does not serve the union mount of volume %q at %s yet", mount.ErrSourceNotReady, volume.VolumeId, volume.HostPath)
+	}
+	return s.mounter.Stage(volume.HostPath, volume.StagingTarget)
+}
+
+// getStagedVolume returns a copy of staged volumeId.
+func (s *nodeServer) getStagedVolume(volumeId string) (*stagedVolume, bool) {
+	s.volumes.mu.Lock()
+	defer s.volumes.mu.Unlock()
+
+	volume, ok := s.volumes.volumes[volumeId]
+	if !ok {
+		return nil, false
+	}
+	copied := *volume
+	copied.Targets = make(map[string]*publishedTarget, len(volume.Targets))
+	for target, t := range volume.Targets {
+		copied.Targets[target] = t
+	}
+	return &copied, true
+}
+
+// isBroken checks if the staging target or any target of volume is a corrupted mount.
+func isBroken(volume *stagedVolume) bool {
+	if mount.IsCorrupted(volume.StagingTarget) {
+		return true
+	}
+	for target := range volume.Targets {
+		if mount.IsCorrupted(target) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,27 +2,42 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
 	klog "k8s.io/klog/v2"
+	mountutils "k8s.io/mount-utils"
 
+	daemon "github.com/on2e/union-csi-driver/gogomergerfs/pkg/daemon"
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
 	csivalidation "github.com/on2e/union-csi-driver/pkg/csi/validation"
 	mount "github.com/on2e/union-csi-driver/pkg/mount"
+	union "github.com/on2e/union-csi-driver/pkg/union"
 )
 
 type nodeServer struct {
-	nodeId    string
-	mounter   mount.Mounter
-	validator csivalidation.NodeValidator
+	nodeId       string
+	union        union.Interface
+	mounter      mount.Mounter
+	daemonClient *daemon.Client
+	validator    csivalidation.NodeValidator
+	// volumes are the volumes staged on the node, for broken union mounts to be remounted
+	volumes *stagedVolumes
+	// stateDir is the state directory of the node plugin
+	stateDir string
+	// pathLocks serializes requests for the same staging target or target path
+	pathLocks *keyLocks
 }
 
 var _ csi.NodeServer = &nodeServer{}
 
-func newNodeServer() *nodeServer {
+func newNodeServer(union union.Interface, driverOptions *driverOptions) *nodeServer {
 	nodeId := os.Getenv("NODE_NAME")
 	if nodeId == "" {
 		err := fmt.Errorf("unset or empty NODE_NAME environment variable")
@@ -33,20 +48,144 @@ func newNodeServer() *nodeServer {
 		panic(err)
 	}
 	return &nodeServer{
-		nodeId:    nodeId,
-		mounter:   mount.NewMounter(),
-		validator: validator,
+		nodeId:       nodeId,
+		union:        union,
+		mounter:      mount.NewMounter(),
+		daemonClient: daemon.NewClient(driverOptions.daemonSocket),
+		validator:    validator,
+		volumes:      newStagedVolumes(filepath.Join(driverOptions.stateDir, stagedVolumesDirName)),
+		stateDir:     driverOptions.stateDir,
+		pathLocks:    newKeyLocks(),
 	}
 }
 
-// Unimplemented.
 func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented NodeStageVolume method")
+	if err := s.validator.NodeStageVolumeRequestValidate(req); err != nil {
+		return nil, err
+	}
+
+	volumeId := req.GetVolumeId()
+	stagingTarget := req.GetStagingTargetPath()
+
+	if !s.pathLocks.tryAcquire(stagingTarget) {
+		return nil, status.Errorf(codes.Aborted, pathOperationPendingFmt, stagingTarget)
+	}
+	defer s.pathLocks.release(stagingTarget)
+
+	stateDir := s.getStateDir(req.GetPublishContext())
+
+	if _, ok := req.GetVolumeCapability().GetAccessType().(*csi.VolumeCapability_Block); ok {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability with access type of block not supported. Support only mount volumes")
+	}
+
+	switch backend := union.AttachBackend(req.GetVolumeContext()[AttachBackendVolumeContextKey]); backend {
+	case union.AttachBackendNode, union.AttachBackendDaemon:
+		klog.InfoS("NodeStageVolume: merging", "VolumeId", volumeId, "StagingTargetPath", stagingTarget, "AttachBackend", backend)
+		if err := s.stageLowerBranches(ctx, volumeId, stagingTarget, backend); err != nil {
+			code := codes.Internal
+			switch {
+			case errors.Is(err, union.ErrVolumeNotFound):
+				code = codes.NotFound
+			case errors.Is(err, merger.ErrBranchMismatch):
+				code = codes.FailedPrecondition
+			}
+			return nil, status.Errorf(code, "Failed to stage volume %s at path %s: %v", volumeId, stagingTarget, err)
+		}
+		klog.InfoS("NodeStageVolume: merged", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+		s.stageVolume(ctx, &stagedVolume{VolumeId: volumeId, StagingTarget: stagingTarget, AttachBackend: backend, StateDir: stateDir})
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	// Unprivileged attach pods serve the union mount in their own mount namespace only.
+	if podPath, ok := req.GetPublishContext()[PodPathPublishContextKey]; ok {
+		klog.InfoS("NodeStageVolume: mounting from attach pod", "VolumeId", volumeId, "StagingTargetPath", stagingTarget, "PodPath", podPath)
+		if err := s.mounter.StageFromPod(podPath, stagingTarget); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to stage volume %s at path %s: %v", volumeId, stagingTarget, err)
+		}
+		klog.InfoS("NodeStageVolume: mounted", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+		s.stageVolume(ctx, &stagedVolume{VolumeId: volumeId, StagingTarget: stagingTarget, AttachBackend: union.AttachBackendPod, PodPath: podPath, StateDir: stateDir})
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	source, err := getPublishContextPath(req.GetPublishContext())
+	if err != nil {
+		return nil, err
+	}
+
+	// The union mount is served on the host by the attach pod at source.
+	// Bind-mount it at the staging target so the staging target is the one union mount
+	// on the node that every target of the volume is published from.
+	klog.InfoS("NodeStageVolume: mounting", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+	if err := s.mounter.Stage(source, stagingTarget); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to stage volume %s at path %s: %v", volumeId, stagingTarget, err)
+	}
+	klog.InfoS("NodeStageVolume: mounted", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+	s.stageVolume(ctx, &stagedVolume{VolumeId: volumeId, StagingTarget: stagingTarget, AttachBackend: union.AttachBackendPod, HostPath: source, StateDir: stateDir})
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// stageVolume keeps track of volume for it to be remounted if its union mount breaks and writes its metadata.
+// Failing to do so does not fail staging, the volume is just not remounted or described.
+func (s *nodeServer) stageVolume(ctx context.Context, volume *stagedVolume) {
+	if err := s.volumes.stage(volume); err != nil {
+		klog.ErrorS(err, "Failed to keep track of staged volume", "VolumeId", volume.VolumeId)
+	}
+	if err := s.writeVolumeMetadata(ctx, volume.StateDir, volume.VolumeId); err != nil {
+		klog.ErrorS(err, "Failed to write volume metadata", "VolumeId", volume.VolumeId, "StateDir", volume.StateDir)
+	}
+}
+
+// unstageVolume removes the metadata of volumeId. Failing to do so does not fail unstaging.
+func (s *nodeServer) unstageVolume(stateDir, volumeId string) {
+	if err := removeVolumeMetadata(stateDir, volumeId); err != nil {
+		klog.ErrorS(err, "Failed to remove volume metadata", "VolumeId", volumeId, "StateDir", stateDir)
+	}
 }
 
-// Unimplemented.
 func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented NodeUnstageVolume method")
+	if err := s.validator.NodeUnstageVolumeRequestValidate(req); err != nil {
+		return nil, err
+	}
+
+	volumeId := req.GetVolumeId()
+	stagingTarget := req.GetStagingTargetPath()
+
+	if !s.pathLocks.tryAcquire(stagingTarget) {
+		return nil, status.Errorf(codes.Aborted, pathOperationPendingFmt, stagingTarget)
+	}
+	defer s.pathLocks.release(stagingTarget)
+
+	// Unstage requests carry no publish context, the state directory is recorded at stage
+	stateDir := s.stateDir
+	if volume, ok := s.getStagedVolume(volumeId); ok && volume.StateDir != "" {
+		stateDir = volume.StateDir
+	}
+
+	// Stop remounting the volume before unmounting it
+	if err := s.volumes.unstage(volumeId); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unstage volume %s at path %s: %v", volumeId, stagingTarget, err)
+	}
+
+	// NodeUnstageVolumeRequest carries no volume context, tell the backend apart by what NodeStageVolume left on disk.
+	if hasLowerBranches(stagingTarget) {
+		klog.InfoS("NodeUnstageVolume: unmerging", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+		if err := s.unstageLowerBranches(ctx, volumeId, stagingTarget); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to unstage volume %s at path %s: %v", volumeId, stagingTarget, err)
+		}
+		klog.InfoS("NodeUnstageVolume: unmerged", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+		s.unstageVolume(stateDir, volumeId)
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	klog.InfoS("NodeUnstageVolume: unmounting", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+	if err := s.mounter.Unstage(stagingTarget); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unstage volume %s at path %s: %v", volumeId, stagingTarget, err)
+	}
+	klog.InfoS("NodeUnstageVolume: unmounted", "VolumeId", volumeId, "StagingTargetPath", stagingTarget)
+	s.unstageVolume(stateDir, volumeId)
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
@@ -56,14 +195,21 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 
 	volumeId := req.GetVolumeId()
 	target := req.GetTargetPath()
+	// Targets are bind-mounted from the staged union mount.
+	source := req.GetStagingTargetPath()
 
-	if len(req.GetPublishContext()) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "publishContext is missing")
+	// Publishing counts the targets published from source, lock it too.
+	if !s.pathLocks.tryAcquire(target, source) {
+		return nil, status.Errorf(codes.Aborted, pathOperationPendingFmt, target)
 	}
-
-	source, ok := req.GetPublishContext()[PathPublishContextKey]
-	if !ok {
-		return nil, status.Errorf(codes.InvalidArgument, "Missing publishContext key: %q", PathPublishContextKey)
+	defer s.pathLocks.release(target, source)
+
+	// The host path that the staged union mount is bind-mounted from is also a reference
+	// of source, do not count it as a published target.
+	// Volumes of the node attach backend are merged at source directly and have no host path.
+	var ignoredRefs []string
+	if hostPath, ok := req.GetPublishContext()[PathPublishContextKey]; ok {
+		ignoredRefs = append(ignoredRefs, hostPath)
 	}
 
 	var mountVolume *csi.VolumeCapability_MountVolume
@@ -75,25 +221,55 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	case *csi.VolumeCapability_Mount:
 		mountVolume = accessType.Mount
 		options = &mount.PublishOptions{
-			FsType:       mountVolume.GetFsType(),
-			ReadOnly:     req.GetReadonly(),
-			MountOptions: mountVolume.GetMountFlags(),
+			FsType:        mountVolume.GetFsType(),
+			ReadOnly:      req.GetReadonly(),
+			MountOptions:  mountVolume.GetMountFlags(),
+			MultiConsumer: isMultiConsumerAccessMode(req.GetVolumeCapability().GetAccessMode().GetMode()),
+			IgnoredRefs:   ignoredRefs,
+			VolumeId:      volumeId,
 		}
 	}
 
 	klog.InfoS("NodePublishVolume: mounting", "VolumeId", volumeId, "TargetPath", target)
-	if err := s.mounter.Publish(source, target, options); err != nil {
+	if err := s.mounter.Publish(ctx, source, target, options); err != nil {
 		code := codes.Internal
 		msg := fmt.Sprintf("Failed to mount volume %s at path %s: %v", volumeId, target, err)
 		switch {
+		case errors.Is(err, mount.ErrSourceInUse):
+			code = codes.FailedPrecondition
+		case errors.Is(err, mount.ErrTargetIncompatible):
+			code = codes.AlreadyExists
+		case errors.Is(err, mount.ErrSourceMismatch):
+			code = codes.FailedPrecondition
+		case errors.Is(err, mount.ErrSourceNotReady):
+			code = codes.Unavailable
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			code = codes.Aborted
 		}
 		return nil, status.Error(code, msg)
 	}
 	klog.InfoS("NodePublishVolume: mounted", "VolumeId", volumeId, "TargetPath", target)
+	if err := s.volumes.publish(volumeId, target, options); err != nil {
+		klog.ErrorS(err, "Failed to keep track of published target", "VolumeId", volumeId, "TargetPath", target)
+	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// getPublishContextPath returns the host path of the union mount set by ControllerPublishVolume.
+func getPublishContextPath(publishContext map[string]string) (string, error) {
+	if len(publishContext) == 0 {
+		return "", status.Error(codes.InvalidArgument, "publishContext is missing")
+	}
+
+	path, ok := publishContext[PathPublishContextKey]
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "Missing publishContext key: %q", PathPublishContextKey)
+	}
+
+	return path, nil
+}
+
 func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	if err := s.validator.NodeUnpublishVolumeRequestValidate(req); err != nil {
 		return nil, err
@@ -102,6 +278,16 @@ func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpub
 	volumeId := req.GetVolumeId()
 	target := req.GetTargetPath()
 
+	if !s.pathLocks.tryAcquire(target) {
+		return nil, status.Errorf(codes.Aborted, pathOperationPendingFmt, target)
+	}
+	defer s.pathLocks.release(target)
+
+	// Stop remounting the target before unmounting it
+	if err := s.volumes.unpublish(volumeId, target); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to unmount volume %s at path %s: %v", volumeId, target, err)
+	}
+
 	klog.InfoS("NodeUnpublishVolume: unmounting", "VolumeId", volumeId, "TargetPath", target)
 	if err := s.mounter.Unpublish(target); err != nil {
 		code := codes.Internal
@@ -115,126 +301,206 @@ func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpub
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-/*func (s *nodeServer) nodePublishVolumeForMount(source, target string, mount *csi.VolumeCapability_MountVolume) error {
-	// Get device ref-counts for source mountpoint (provided by PublishContext from ControllerPublishVolume).
-	// * If refs is 0, it means we are missing the mount from ControllerPublishVolume and cannot proceed.
-	// * If refs is 1, it means that we only have the source mountpoint and can proceed.
-	// * If refs is 2, it means that (probably) we have the initial mount from ControllerPublishVolume plus one NodePublishVolume mount:
-	//   * In this case we can check if the NodePublishVolume mountpoint is the same as target and if it is, we can check for VolumeCapability and Readonly
-	//   fields idempotency compatibility between the published volume at target and the requested volume at target (how we do that?).
-	//   * If the NodePublishVolume mountpoint is NOT the same as target we can check if we support SINGLE_NODE_MULTI_WRITER or MULTI_NODE_...
-	//   and decide if we should proceed with more than 1 NodePublishVolume mounts on different targets. This is also the same approach for refs > 2.
-	var (
-		device string
-		refs   []string
-		err    error
-	)
-
-	device, refs, err = mounter.GetDeviceNameAndMountsFromMount(s.mounter, source)
-	if err != nil {
-		return status.Errorf(codes.Internal, "Failed to get device mounted at %s: %v", source, err)
+// isMultiConsumerAccessMode checks if mode allows a volume to be published at more than one target on a node.
+func isMultiConsumerAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	default:
+		return false
 	}
+}
 
-	if device == "" {
-		klog.Infof("%s is not mounted by a device", source)
-		// Is this a "Volume does not exist: 5 NOT_FOUND" error?
-		// What is "Volume does not exist" for the nodeServer:
-		// Is it that VolumeSplit exists or/and device at source path exists?
-		return status.Errorf(codes.Internal, "%s is not a mountpoint, cannot mount at target %s", source, target)
+func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if err := s.validator.NodeGetVolumeStatsRequestValidate(req); err != nil {
+		return nil, err
 	}
 
-	// Can check here if device has any relation with volumeId
+	volumeId := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
 
-	//klog.InfoS("NodePublishVolume", "Device", device, "Source", source, "Mountpoints", refs, "Target", target)
+	// A corrupted union mount, e.g. mergerfs is gone, is reported as an abnormal volume condition
+	// instead of an error so kubelet can surface it.
+	exists, err := mountutils.PathExists(volumePath)
+	if err != nil && !mountutils.IsCorruptedMnt(err) {
+		return nil, status.Errorf(codes.Internal, "Failed to check if volume path %s exists: %v", volumePath, err)
+	}
+	if err == nil && !exists {
+		return nil, status.Errorf(codes.NotFound, "Volume path %s does not exist", volumePath)
+	}
 
-	switch l := len(refs); {
-	case l == 0: // source is the only mountpoint of device.
-	case l == 1 && refs[0] == target: // device is mounted at target.
-	default:
-		// T1!=T2: (in l > 1 case, T1 is "undefined" -> l == 1: s!=target, l > 1: [...]!=target)
-		// if *MULTI* { OK }
-		// else       { FAILED_PRECONDITION }
-		if !s.supportsMulti() {
-			// FAILED_PRECONDITION
-			return status.Errorf(
-				codes.FailedPrecondition,
-				"Device %s mounted at %s found also mounted at 1 or more mountpoints that are not target %s"+
-					" but multi-consumer volume capability access mode is not supported", device, source, target,
-			)
+	var stats *mount.VolumeStats
+	if err == nil {
+		stats, err = s.mounter.GetVolumeStats(volumePath)
+		if err != nil && !mountutils.IsCorruptedMnt(err) {
+			return nil, status.Errorf(codes.Internal, "Failed to get stats of volume %s at path %s: %v", volumeId, volumePath, err)
 		}
 	}
+	if err != nil {
+		klog.InfoS("NodeGetVolumeStats: volume path is corrupted", "VolumeId", volumeId, "VolumePath", volumePath, "err", err)
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Union mount at %s is corrupted: %v", volumePath, err),
+			},
+		}, nil
+	}
 
-	// Check if target is mounted by device.
-	var isMnt bool
-	for _, mp := range refs {
-		if mp == target {
-			isMnt = true
-			break
+	volumeCondition := s.getVolumeCondition(volumePath)
+	if s.volumes.isRemounted(volumeId, volumePath) {
+		volumeCondition = &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Union mount at %s was remounted after it broke, consumers not receiving mounts from the host need to restart to see it", volumePath),
 		}
 	}
 
-	// The device is also mounted at target.
-	if isMnt {
-		// We should somehow check that the device is indeed backed by the volume of volumeId
-		// and is idempotent-compatible with requested VolumeCapability and Readonly fields.
-		// Assume it is for now and return nil error.
-		// T1=T2:
-		// if P1=P2 { OK (idempotent) }
-		// else     { ALREADY_EXISTS }
-		klog.InfoS("NodePublishVolume: target already mounted, return OK", "Device", device, "Source", source, "Target", target)
-		return nil
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Available: stats.AvailableBytes,
+				Total:     stats.TotalBytes,
+				Used:      stats.UsedBytes,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Available: stats.AvailableInodes,
+				Total:     stats.TotalInodes,
+				Used:      stats.UsedInodes,
+			},
+		},
+		VolumeCondition: volumeCondition,
+	}, nil
+}
+
+// getVolumeCondition reports the union mount at volumePath as abnormal if any of its branches is unreachable.
+// The free space of every reachable branch is listed in the condition message.
+func (s *nodeServer) getVolumeCondition(volumePath string) *csi.VolumeCondition {
+	branches, err := s.mounter.GetBranchStats(volumePath)
+	if err != nil {
+		// Branches are unknown, do not report the volume abnormal just for that.
+		klog.InfoS("NodeGetVolumeStats: failed to get branch stats", "VolumePath", volumePath, "err", err)
+		return &csi.VolumeCondition{Abnormal: false, Message: "Union mount is healthy"}
 	}
 
-	// Check if target is mounted by a filesystem other than device.
-	isMnt, err = s.mounter.IsMountPoint(target)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		if !mountutils.IsCorruptedMnt(err) {
-			return status.Errorf(codes.Internal, "Failed to determine if target %s is a mountpoint: %v", target, err)
+	abnormal := false
+	msgs := []string{}
+	for _, branch := range branches {
+		if branch.Err != nil {
+			abnormal = true
+			msgs = append(msgs, fmt.Sprintf("branch %s is unreachable: %v", branch.Path, branch.Err))
+			continue
 		}
-		klog.Infof("Target %s is likely a corrupted mountpoint, attempting to clean up and mount", target)
-		if err := mountutils.CleanupMountPoint(target, s.mounter, true); err != nil {
-			if strings.Contains(fmt.Sprint(err), "not mounted") {
-				klog.Infof("Ignoring 'not mounted' error for target %s", target)
-			} else {
-				return status.Errorf(codes.Internal, "Failed to clean up target %s: %v", target, err)
-			}
+		msgs = append(msgs, fmt.Sprintf("branch %s has %d of %d bytes available", branch.Path, branch.Stats.AvailableBytes, branch.Stats.TotalBytes))
+	}
+
+	msg := "Union mount is healthy"
+	if abnormal {
+		msg = "Union mount has unreachable branches"
+	}
+	if len(msgs) > 0 {
+		msg += ": " + strings.Join(msgs, "; ")
+	}
+
+	return &csi.VolumeCondition{Abnormal: abnormal, Message: msg}
+}
+
+func (s *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	if err := s.validator.NodeExpandVolumeRequestValidate(req); err != nil {
+		return nil, err
+	}
+
+	volumeId := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+	requiredBytes := req.GetCapacityRange().GetRequiredBytes()
+
+	if _, ok := req.GetVolumeCapability().GetAccessType().(*csi.VolumeCapability_Block); ok {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability with access type of block not supported. Support only mount volumes")
+	}
+
+	exists, err := mountutils.PathExists(volumePath)
+	if err != nil {
+		if mountutils.IsCorruptedMnt(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "Volume path %s is corrupted: %v", volumePath, err)
 		}
-		klog.Infof("Successfully cleaned up target %s", target)
+		return nil, status.Errorf(codes.Internal, "Failed to check if volume path %s exists: %v", volumePath, err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "Volume path %s does not exist", volumePath)
 	}
 
-	if isMnt {
-		return status.Errorf(codes.Internal, "Target %s is already mounted by a different device, will not attempt to mount", target)
+	volume, ok := s.getStagedVolume(volumeId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Volume %s is not staged on the node", volumeId)
 	}
+	stagingTarget := volume.StagingTarget
 
-	// Create target dir.
-	if err := os.MkdirAll(target, 0o755); err != nil {
-		return status.Errorf(codes.Internal, "Failed to create target directory %s", target)
+	if !s.pathLocks.tryAcquire(stagingTarget) {
+		return nil, status.Errorf(codes.Aborted, pathOperationPendingFmt, stagingTarget)
 	}
+	defer s.pathLocks.release(stagingTarget)
 
-	// Validate mount fields.
-	mountOptions := []string{"bind"}
+	klog.InfoS("NodeExpandVolume: expanding", "VolumeId", volumeId, "VolumePath", volumePath, "RequiredBytes", requiredBytes)
+	if err := s.expandVolume(ctx, volume); err != nil {
+		code := codes.Internal
+		if errors.Is(err, union.ErrOperationPending) || errors.Is(err, union.ErrOperationConflict) || errors.Is(err, union.ErrLowerClaimPending) ||
+			errors.Is(err, union.ErrAttachmentNotFound) || errors.Is(err, union.ErrAttachUnavailable) {
+			code = codes.Unavailable
+		} else if errors.Is(err, union.ErrExpandPrecondition) {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Errorf(code, "Failed to expand volume %s: %v", volumeId, err)
+	}
 
-	// Proceed to mount source at target.
-	klog.Infof("Mounting source %s at target %s", source, target)
-	if err := s.mounter.Mount(source, target, "fuse.mergerfs", mountOptions); err != nil {
-		return status.Errorf(codes.Internal, "Failed to mount source %s at target %s: %v", source, target, err)
+	// The union mount holds the sum of the lower claims once all of them are merged,
+	// statfs of the union mount falls short of it by the overhead of the lower filesystems.
+	capacityBytes, err := s.union.GetLowerCapacity(ctx, volumeId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, union.ErrLowerClaimPending) {
+			code = codes.Unavailable
+		}
+		return nil, status.Errorf(code, "Failed to get capacity of volume %s: %v", volumeId, err)
+	}
+	if capacityBytes < requiredBytes {
+		return nil, status.Errorf(codes.Internal, "Lower claims of volume %s hold %d bytes, %d required", volumeId, capacityBytes, requiredBytes)
 	}
+	klog.InfoS("NodeExpandVolume: expanded", "VolumeId", volumeId, "VolumePath", volumePath, "CapacityBytes", capacityBytes)
 
-	return nil
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacityBytes}, nil
 }
 
-func (s *nodeServer) supportsMulti() bool {
-	return false
-}*/
+// expandVolume has the union mount of volume merge all the lower claims of the volume, the ones added by
+// ControllerExpandVolume included. The node plugin and the gogomergerfs daemon take new branches at runtime,
+// while the lower volumes of a running attach pod are fixed, so volumes of the pod attach backend are only expanded
+// offline: ControllerExpandVolume refuses them while attached and their next attach pod merges all the lower claims.
+func (s *nodeServer) expandVolume(ctx context.Context, volume *stagedVolume) error {
+	if mount.IsCorrupted(volume.StagingTarget) {
+		return fmt.Errorf("%w: union mount of volume %q is broken, waiting for it to be remounted", union.ErrAttachUnavailable, volume.VolumeId)
+	}
 
-// Unimplemented.
-func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented NodeGetVolumeStats method")
-}
+	upper, err := s.union.GetVolume(ctx, volume.VolumeId)
+	if err != nil {
+		return err
+	}
+	branches, err := s.mounter.ListBranches(volume.StagingTarget)
+	if err != nil {
+		return err
+	}
+	if len(branches) >= len(upper.ClaimNames) {
+		// Have mergerfs re-evaluate its branches in case any of them was expanded in place or remounted.
+		return s.mounter.RefreshBranches(volume.StagingTarget)
+	}
 
-// Unimplemented.
-func (s *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented NodeExpandVolume method")
+	switch volume.AttachBackend {
+	case union.AttachBackendNode, union.AttachBackendDaemon:
+		klog.InfoS("Merging new lower claims", "VolumeId", volume.VolumeId, "Branches", len(branches), "LowerClaims", len(upper.ClaimNames), "AttachBackend", volume.AttachBackend)
+		return s.expandLowerBranches(ctx, volume.VolumeId, volume.StagingTarget)
+	}
+	return fmt.Errorf("%w: attach pod of volume %q merges %d of %d lower claims, volumes of the %q attach backend are expanded while detached",
+		union.ErrExpandPrecondition, volume.VolumeId, len(branches), len(upper.ClaimNames), union.AttachBackendPod)
 }
 
 func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -245,5 +511,8 @@ func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReques
 	return &csi.NodeGetInfoResponse{
 		NodeId:            s.nodeId,
 		MaxVolumesPerNode: 0,
+		// The accessible topology of volumes uses the labels of the lower storage, this only gives the provisioner
+		// a topology key to find on every node
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{NodeIdTopologyKey: s.nodeId}},
 	}, nil
 }
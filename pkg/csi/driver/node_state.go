@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+// getStateDir returns the state directory the controller published the volume with,
+// the state directory of the node plugin for volumes published before it did.
+func (s *nodeServer) getStateDir(publishContext map[string]string) string {
+	if stateDir, ok := publishContext[StateDirPublishContextKey]; ok && stateDir != "" {
+		return stateDir
+	}
+	return s.stateDir
+}
+
+// writeVolumeMetadata writes the metadata of volumeId in its directory under stateDir,
+// keeping the creation time of any metadata written before.
+func (s *nodeServer) writeVolumeMetadata(ctx context.Context, stateDir, volumeId string) error {
+	volume, err := s.union.GetVolume(ctx, volumeId)
+	if err != nil {
+		return err
+	}
+
+	metadata := &union.VolumeMetadata{
+		VolumeId:      volumeId,
+		AttachBackend: volume.AttachBackend,
+		CreationTime:  time.Now(),
+	}
+	for _, claimName := range volume.ClaimNames {
+		metadata.Branches = append(metadata.Branches, volume.Namespace+"/"+claimName)
+	}
+
+	path := union.MakeVolumeMetadataPath(stateDir, volumeId)
+	if old, err := readVolumeMetadata(path); err == nil && old.VolumeId == volumeId {
+		metadata.CreationTime = old.CreationTime
+	}
+
+	dir := union.MakeVolumeDir(stateDir, volumeId)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create volume directory %s: %v", dir, err)
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error encoding metadata of volume %q: %v", volumeId, err)
+	}
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// removeVolumeMetadata removes the metadata of volumeId and its directory under stateDir,
+// unless the union mount is still served in it.
+func removeVolumeMetadata(stateDir, volumeId string) error {
+	path := union.MakeVolumeMetadataPath(stateDir, volumeId)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+	// The attach pod may not have been deleted yet, leave its directories alone then.
+	for _, dir := range []string{union.MakeMergedDir(stateDir, volumeId), union.MakeVolumeDir(stateDir, volumeId)} {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			klog.V(4).Infof("Leaving %s in place: %v", dir, err)
+			break
+		}
+	}
+	return nil
+}
+
+func readVolumeMetadata(path string) (*union.VolumeMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &union.VolumeMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", path, err)
+	}
+	return metadata, nil
+}
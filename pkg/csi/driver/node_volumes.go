@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	klog "k8s.io/klog/v2"
+
+	mount "github.com/on2e/union-csi-driver/pkg/mount"
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+// stagedVolume is what the node plugin needs to know to remount a staged volume after its union mount broke.
+type stagedVolume struct {
+	VolumeId      string              `json:"volumeId"`
+	StagingTarget string              `json:"stagingTarget"`
+	AttachBackend union.AttachBackend `json:"attachBackend"`
+	// HostPath or PodPath is where the attach pod serves the union mount, for volumes of the pod attach backend
+	HostPath string `json:"hostPath,omitempty"`
+	PodPath  string `json:"podPath,omitempty"`
+	// StateDir is the state directory the volume was published with
+	StateDir string `json:"stateDir,omitempty"`
+	// Targets are the targets the volume is published at
+	Targets map[string]*publishedTarget `json:"targets,omitempty"`
+}
+
+type publishedTarget struct {
+	Options *mount.PublishOptions `json:"options"`
+	// Remounted is set once the target is remounted after its union mount broke.
+	// Consumers that do not receive mounts from the host keep seeing the broken mount until they restart.
+	Remounted bool `json:"remounted,omitempty"`
+}
+
+// stagedVolumes keeps the volumes staged on the node, persisted under dir so they survive node plugin restarts.
+type stagedVolumes struct {
+	// mu guards volumes and their files only, mounts are serialized by the path locks of the node server
+	mu      sync.Mutex
+	dir     string
+	volumes map[string]*stagedVolume
+}
+
+func newStagedVolumes(dir string) *stagedVolumes {
+	v := &stagedVolumes{
+		dir:     dir,
+		volumes: make(map[string]*stagedVolume),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Errorf("Failed to read staged volumes directory %s: %v", dir, err)
+		}
+		return v
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.Errorf("Failed to read staged volume %s: %v", path, err)
+			continue
+		}
+		volume := &stagedVolume{}
+		if err := json.Unmarshal(data, volume); err != nil {
+			klog.Errorf("Error decoding staged volume %s: %v", path, err)
+			continue
+		}
+		v.volumes[volume.VolumeId] = volume
+	}
+	klog.Infof("Loaded %d staged volume(s) from %s", len(v.volumes), dir)
+
+	return v
+}
+
+// stage records that volume is staged, keeping the targets it is already published at.
+func (v *stagedVolumes) stage(volume *stagedVolume) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if old, ok := v.volumes[volume.VolumeId]; ok && volume.Targets == nil {
+		volume.Targets = old.Targets
+	}
+	v.volumes[volume.VolumeId] = volume
+	return v.write(volume)
+}
+
+// unstage forgets volumeId. Called before unstaging so that volumeId is not remounted in the meantime.
+func (v *stagedVolumes) unstage(volumeId string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.volumes, volumeId)
+	path := v.path(volumeId)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove staged volume %s: %v", path, err)
+	}
+	return nil
+}
+
+// publish records that volumeId is published at target with options.
+// Volumes staged before the node plugin kept track of them are not recorded.
+func (v *stagedVolumes) publish(volumeId, target string, options *mount.PublishOptions) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volume, ok := v.volumes[volumeId]
+	if !ok {
+		return nil
+	}
+	if volume.Targets == nil {
+		volume.Targets = make(map[string]*publishedTarget)
+	}
+	volume.Targets[target] = &publishedTarget{Options: options}
+	return v.write(volume)
+}
+
+// unpublish forgets target of volumeId. Called before unpublishing so that target is not remounted in the meantime.
+func (v *stagedVolumes) unpublish(volumeId, target string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volume, ok := v.volumes[volumeId]
+	if !ok {
+		return nil
+	}
+	if _, ok := volume.Targets[target]; !ok {
+		return nil
+	}
+	delete(volume.Targets, target)
+	return v.write(volume)
+}
+
+// remounted records that target of volumeId was remounted after its union mount broke.
+func (v *stagedVolumes) remounted(volumeId, target string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volume, ok := v.volumes[volumeId]
+	if !ok {
+		return nil
+	}
+	t, ok := volume.Targets[target]
+	if !ok {
+		return nil
+	}
+	t.Remounted = true
+	return v.write(volume)
+}
+
+// isRemounted checks if target of volumeId was remounted after its union mount broke.
+func (v *stagedVolumes) isRemounted(volumeId, target string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volume, ok := v.volumes[volumeId]
+	if !ok {
+		return false
+	}
+	t, ok := volume.Targets[target]
+	return ok && t.Remounted
+}
+
+// list returns the IDs of the staged volumes.
+func (v *stagedVolumes) list() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	volumeIds := make([]string, 0, len(v.volumes))
+	for volumeId := range v.volumes {
+		volumeIds = append(volumeIds, volumeId)
+	}
+	return volumeIds
+}
+
+// write persists volume. Must be called with mu held.
+func (v *stagedVolumes) write(volume *stagedVolume) error {
+	if err := os.MkdirAll(v.dir, 0750); err != nil {
+		return fmt.Errorf("failed to create staged volumes directory %s: %v", v.dir, err)
+	}
+	data, err := json.Marshal(volume)
+	if err != nil {
+		return fmt.Errorf("error encoding staged volume %q: %v", volume.VolumeId, err)
+	}
+	path := v.path(volume.VolumeId)
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// path returns <dir>/<sha256(volumeId)>.json
+func (v *stagedVolumes) path(volumeId string) string {
+	return filepath.Join(v.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(volumeId))))
+}
@@ -14,6 +14,7 @@ type ControllerServerRequestValidator interface {
 	DeleteVolumeRequestValidate(*csi.DeleteVolumeRequest) error
 	ControllerPublishVolumeRequestValidate(*csi.ControllerPublishVolumeRequest) error
 	ControllerUnpublishVolumeRequestValidate(*csi.ControllerUnpublishVolumeRequest) error
+	ControllerExpandVolumeRequestValidate(*csi.ControllerExpandVolumeRequest) error
 }
 
 type ControllerValidator interface {
@@ -135,6 +136,24 @@ func (v *controllerValidator) ControllerUnpublishVolumeRequestValidate(req *csi.
 	return nil
 }
 
+func (v *controllerValidator) ControllerExpandVolumeRequestValidate(req *csi.ControllerExpandVolumeRequest) error {
+	if !v.controllerCapTypesSet.Has(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME) {
+		return status.Errorf(codes.Unimplemented, "plugin does not support controller capability %v", csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+	}
+
+	if errs := ValidateControllerExpandVolumeRequest(req); len(errs) > 0 {
+		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
+	}
+
+	if req.VolumeCapability != nil {
+		if mode := req.VolumeCapability.AccessMode.Mode; !v.volumeValidator.HasVolumeCapabilityMode(mode) {
+			return status.Errorf(codes.InvalidArgument, "Plugin does not support access mode: %v. Supported access modes: %v", mode, v.volumeValidator.GetVolumeCapabilityModes())
+		}
+	}
+
+	return nil
+}
+
 func (v *controllerValidator) GetControllerCapabilities() []*csi.ControllerServiceCapability {
 	return v.controllerCaps
 }
@@ -20,6 +20,12 @@ var validPluginCapTypes = sets.New[csi.PluginCapability_Service_Type](
 	csi.PluginCapability_Service_GROUP_CONTROLLER_SERVICE,
 )
 
+// Exclude csi.PluginCapability_VolumeExpansion_UNKNOWN, which stands for no volume expansion support.
+var validVolumeExpansionTypes = sets.New[csi.PluginCapability_VolumeExpansion_Type](
+	csi.PluginCapability_VolumeExpansion_ONLINE,
+	csi.PluginCapability_VolumeExpansion_OFFLINE,
+)
+
 type identityValidator struct {
 	pluginCapTypesSet sets.Set[csi.PluginCapability_Service_Type]
 	pluginCaps        []*csi.PluginCapability
@@ -27,7 +33,9 @@ type identityValidator struct {
 
 var _ IdentityValidator = &identityValidator{}
 
-func NewIdentityValidator(pluginCapTypes []csi.PluginCapability_Service_Type) (*identityValidator, error) {
+// NewIdentityValidator returns an IdentityValidator of the plugin capabilities pluginCapTypes and volumeExpansionType,
+// csi.PluginCapability_VolumeExpansion_UNKNOWN for plugins that do not support volume expansion.
+func NewIdentityValidator(pluginCapTypes []csi.PluginCapability_Service_Type, volumeExpansionType csi.PluginCapability_VolumeExpansion_Type) (*identityValidator, error) {
 	v := &identityValidator{}
 
 	for _, k := range pluginCapTypes {
@@ -52,6 +60,20 @@ func NewIdentityValidator(pluginCapTypes []csi.PluginCapability_Service_Type) (*
 		v.pluginCaps = append(v.pluginCaps, cap)
 	}
 
+	if volumeExpansionType != csi.PluginCapability_VolumeExpansion_UNKNOWN {
+		if !validVolumeExpansionTypes.Has(volumeExpansionType) {
+			return nil, fmt.Errorf("unsupported volume expansion: %v. Supported values: %v", volumeExpansionType, sets.List[csi.PluginCapability_VolumeExpansion_Type](validVolumeExpansionTypes))
+		}
+		cap := &csi.PluginCapability{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: volumeExpansionType,
+				},
+			},
+		}
+		v.pluginCaps = append(v.pluginCaps, cap)
+	}
+
 	return v, nil
 }
 
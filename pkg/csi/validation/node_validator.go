@@ -10,8 +10,12 @@ import (
 )
 
 type NodeServerRequestValidator interface {
+	NodeStageVolumeRequestValidate(req *csi.NodeStageVolumeRequest) error
+	NodeUnstageVolumeRequestValidate(req *csi.NodeUnstageVolumeRequest) error
 	NodePublishVolumeRequestValidate(req *csi.NodePublishVolumeRequest) error
 	NodeUnpublishVolumeRequestValidate(req *csi.NodeUnpublishVolumeRequest) error
+	NodeGetVolumeStatsRequestValidate(req *csi.NodeGetVolumeStatsRequest) error
+	NodeExpandVolumeRequestValidate(req *csi.NodeExpandVolumeRequest) error
 }
 
 type NodeValidator interface {
@@ -80,6 +84,34 @@ func NewNodeValidator(
 	return v, nil
 }
 
+func (v *nodeValidator) NodeStageVolumeRequestValidate(req *csi.NodeStageVolumeRequest) error {
+	if !v.nodeCapTypesSet.Has(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME) {
+		return status.Errorf(codes.Unimplemented, "plugin does not support node capability %v", csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	}
+
+	if errs := ValidateNodeStageVolumeRequest(req); len(errs) > 0 {
+		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
+	}
+
+	if mode := req.VolumeCapability.AccessMode.Mode; !v.volumeValidator.HasVolumeCapabilityMode(mode) {
+		return status.Errorf(codes.InvalidArgument, "Plugin does not support access mode: %v. Supported access modes: %v", mode, v.volumeValidator.GetVolumeCapabilityModes())
+	}
+
+	return nil
+}
+
+func (v *nodeValidator) NodeUnstageVolumeRequestValidate(req *csi.NodeUnstageVolumeRequest) error {
+	if !v.nodeCapTypesSet.Has(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME) {
+		return status.Errorf(codes.Unimplemented, "plugin does not support node capability %v", csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	}
+
+	if errs := ValidateNodeUnstageVolumeRequest(req); len(errs) > 0 {
+		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
+	}
+
+	return nil
+}
+
 func (v *nodeValidator) NodePublishVolumeRequestValidate(req *csi.NodePublishVolumeRequest) error {
 	if errs := ValidateNodePublishVolumeRequest(req); len(errs) > 0 {
 		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
@@ -107,6 +139,36 @@ func (v *nodeValidator) NodeUnpublishVolumeRequestValidate(req *csi.NodeUnpublis
 	return nil
 }
 
+func (v *nodeValidator) NodeGetVolumeStatsRequestValidate(req *csi.NodeGetVolumeStatsRequest) error {
+	if !v.nodeCapTypesSet.Has(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS) {
+		return status.Errorf(codes.Unimplemented, "plugin does not support node capability %v", csi.NodeServiceCapability_RPC_GET_VOLUME_STATS)
+	}
+
+	if errs := ValidateNodeGetVolumeStatsRequest(req); len(errs) > 0 {
+		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
+	}
+
+	return nil
+}
+
+func (v *nodeValidator) NodeExpandVolumeRequestValidate(req *csi.NodeExpandVolumeRequest) error {
+	if !v.nodeCapTypesSet.Has(csi.NodeServiceCapability_RPC_EXPAND_VOLUME) {
+		return status.Errorf(codes.Unimplemented, "plugin does not support node capability %v", csi.NodeServiceCapability_RPC_EXPAND_VOLUME)
+	}
+
+	if errs := ValidateNodeExpandVolumeRequest(req); len(errs) > 0 {
+		return status.Errorf(codes.InvalidArgument, errs.ToAggregate().Error())
+	}
+
+	if req.VolumeCapability != nil {
+		if mode := req.VolumeCapability.AccessMode.Mode; !v.volumeValidator.HasVolumeCapabilityMode(mode) {
+			return status.Errorf(codes.InvalidArgument, "Plugin does not support access mode: %v. Supported access modes: %v", mode, v.volumeValidator.GetVolumeCapabilityModes())
+		}
+	}
+
+	return nil
+}
+
 func (v *nodeValidator) GetNodeCapabilities() []*csi.NodeServiceCapability {
 	return v.nodeCaps
 }
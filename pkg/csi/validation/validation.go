@@ -258,8 +258,53 @@ func ValidateControllerUnpublishVolumeRequest(req *csi.ControllerUnpublishVolume
 	return allErrs
 }
 
+func ValidateControllerExpandVolumeRequest(req *csi.ControllerExpandVolumeRequest) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(req.VolumeId) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("volumeId"), ""))
+	}
+
+	if req.VolumeCapability != nil { // optional field
+		allErrs = append(allErrs, validateVolumeCapability(req.VolumeCapability, field.NewPath("volumeCapability"))...)
+	}
+
+	if req.CapacityRange == nil {
+		allErrs = append(allErrs, field.Required(field.NewPath("capacityRange"), ""))
+	} else {
+		allErrs = append(allErrs, validateCapacityRange(req.CapacityRange, field.NewPath("capacityRange"))...)
+	}
+
+	return allErrs
+}
+
 // Node service request validation.
 
+func ValidateNodeStageVolumeRequest(req *csi.NodeStageVolumeRequest) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(req.VolumeId) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("volumeId"), ""))
+	}
+
+	allErrs = append(allErrs, validateTargetPath(req.StagingTargetPath, field.NewPath("stagingTargetPath"))...)
+	allErrs = append(allErrs, validateVolumeCapability(req.VolumeCapability, field.NewPath("volumeCapability"))...)
+
+	return allErrs
+}
+
+func ValidateNodeUnstageVolumeRequest(req *csi.NodeUnstageVolumeRequest) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(req.VolumeId) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("volumeId"), ""))
+	}
+
+	allErrs = append(allErrs, validateTargetPath(req.StagingTargetPath, field.NewPath("stagingTargetPath"))...)
+
+	return allErrs
+}
+
 func ValidateNodePublishVolumeRequest(req *csi.NodePublishVolumeRequest) field.ErrorList {
 	allErrs := field.ErrorList{}
 
@@ -304,4 +349,41 @@ func ValidateNodeUnpublishVolumeRequest(req *csi.NodeUnpublishVolumeRequest) fie
 	return allErrs
 }
 
+func ValidateNodeGetVolumeStatsRequest(req *csi.NodeGetVolumeStatsRequest) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(req.VolumeId) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("volumeId"), ""))
+	}
+
+	if len(req.StagingTargetPath) > 0 { // optional field
+		allErrs = append(allErrs, validateTargetPath(req.StagingTargetPath, field.NewPath("stagingTargetPath"))...)
+	}
+
+	allErrs = append(allErrs, validateTargetPath(req.VolumePath, field.NewPath("volumePath"))...)
+
+	return allErrs
+}
+
+func ValidateNodeExpandVolumeRequest(req *csi.NodeExpandVolumeRequest) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(req.VolumeId) == 0 {
+		allErrs = append(allErrs, field.Required(field.NewPath("volumeId"), ""))
+	}
+
+	if len(req.StagingTargetPath) > 0 { // optional field
+		allErrs = append(allErrs, validateTargetPath(req.StagingTargetPath, field.NewPath("stagingTargetPath"))...)
+	}
+
+	if req.VolumeCapability != nil { // optional field
+		allErrs = append(allErrs, validateVolumeCapability(req.VolumeCapability, field.NewPath("volumeCapability"))...)
+	}
+
+	allErrs = append(allErrs, validateTargetPath(req.VolumePath, field.NewPath("volumePath"))...)
+	allErrs = append(allErrs, validateCapacityRange(req.CapacityRange, field.NewPath("capacityRange"))...)
+
+	return allErrs
+}
+
 // TODO: add validation for rest gRPC requests
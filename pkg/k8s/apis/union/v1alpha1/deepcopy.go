@@ -10,6 +10,7 @@ func (in *VolumeSplit) DeepCopyInto(out *VolumeSplit) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 func (in *VolumeSplit) DeepCopy() *VolumeSplit {
@@ -35,6 +36,11 @@ func (in *VolumeSplitSpec) DeepCopyInto(out *VolumeSplitSpec) {
 		*out = make([]v1.PersistentVolumeAccessMode, len(*in))
 		copy(*out, *in)
 	}
+	if in.CSIAccessModes != nil {
+		in, out := &in.CSIAccessModes, &out.CSIAccessModes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.StorageClassName != nil {
 		in, out := &in.StorageClassName, &out.StorageClassName
 		*out = new(string)
@@ -48,6 +54,15 @@ func (in *VolumeSplitSpec) DeepCopyInto(out *VolumeSplitSpec) {
 		}
 	}
 	in.CapacityTotal.DeepCopyInto(&out.CapacityTotal)
+	if in.ClaimRef != nil {
+		in, out := &in.ClaimRef, &out.ClaimRef
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	if in.NodeTopology != nil {
+		in, out := &in.NodeTopology, &out.NodeTopology
+		*out = (*in).DeepCopy()
+	}
 }
 
 func (in *VolumeSplitSpec) DeepCopy() *VolumeSplitSpec {
@@ -59,6 +74,40 @@ func (in *VolumeSplitSpec) DeepCopy() *VolumeSplitSpec {
 	return out
 }
 
+func (in *VolumeSplitStatus) DeepCopyInto(out *VolumeSplitStatus) {
+	*out = *in
+	if in.Attachments != nil {
+		in, out := &in.Attachments, &out.Attachments
+		*out = make([]VolumeSplitAttachment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+func (in *VolumeSplitStatus) DeepCopy() *VolumeSplitStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSplitStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *VolumeSplitAttachment) DeepCopyInto(out *VolumeSplitAttachment) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+func (in *VolumeSplitAttachment) DeepCopy() *VolumeSplitAttachment {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSplitAttachment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 func (in *PersistentVolumeClaimSplit) DeepCopyInto(out *PersistentVolumeClaimSplit) {
 	*out = *in
 	in.Resources.DeepCopyInto(&out.Resources)
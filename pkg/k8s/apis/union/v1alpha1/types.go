@@ -8,7 +8,8 @@ import (
 type VolumeSplit struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
-	Spec              VolumeSplitSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+	Spec              VolumeSplitSpec   `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+	Status            VolumeSplitStatus `json:"status,omitempty" protobuf:"bytes,3,opt,name=status"`
 }
 
 type VolumeSplitList struct {
@@ -18,15 +19,51 @@ type VolumeSplitList struct {
 }
 
 type VolumeSplitSpec struct {
-	VolumeName       string                          `json:"volumeName,omitempty" protobuf:"bytes,1,name=volumeName"`
-	CapacityTotal    v1.ResourceList                 `json:"capacityTotal,omitempty" protobuf:"bytes,5,name=capacityTotal"`
-	AccessModes      []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty" protobuf:"bytes,4,rep,name=accessModes,casttype=PersistentVolumeAccessMode"`
-	Namespace        string                          `json:"namespace,omitempty" protobuf:"bytes,2,name=namespace"`
-	StorageClassName *string                         `json:"storageClassName,omitempty" protobuf:"bytes,3,opt,name=storageClassName"`
-	Splits           []PersistentVolumeClaimSplit    `json:"splits,omitempty" protobuf:"bytes,6,rep,name=splits"`
+	VolumeName        string                          `json:"volumeName,omitempty" protobuf:"bytes,1,name=volumeName"`
+	CapacityTotal     v1.ResourceList                 `json:"capacityTotal,omitempty" protobuf:"bytes,5,name=capacityTotal"`
+	AccessModes       []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty" protobuf:"bytes,4,rep,name=accessModes,casttype=PersistentVolumeAccessMode"`
+	Namespace         string                          `json:"namespace,omitempty" protobuf:"bytes,2,name=namespace"`
+	StorageClassName  *string                         `json:"storageClassName,omitempty" protobuf:"bytes,3,opt,name=storageClassName"`
+	Splits            []PersistentVolumeClaimSplit    `json:"splits,omitempty" protobuf:"bytes,6,rep,name=splits"`
+	AttachBackend     string                          `json:"attachBackend,omitempty" protobuf:"bytes,7,opt,name=attachBackend"`
+	AttachPodTemplate string                          `json:"attachPodTemplate,omitempty" protobuf:"bytes,8,opt,name=attachPodTemplate"`
+	ClaimRef          *v1.ObjectReference             `json:"claimRef,omitempty" protobuf:"bytes,9,opt,name=claimRef"`
+	// NodeTopology selects the nodes of the topology segment the lower claims were sized for, if any
+	NodeTopology *metav1.LabelSelector `json:"nodeTopology,omitempty" protobuf:"bytes,10,opt,name=nodeTopology"`
+	// CSIAccessModes are the CSI access modes the volume was created with, e.g. SINGLE_NODE_READER_ONLY,
+	// which AccessModes cannot tell apart from their multi-node counterparts
+	CSIAccessModes []string `json:"csiAccessModes,omitempty" protobuf:"bytes,11,rep,name=csiAccessModes"`
 }
 
 type PersistentVolumeClaimSplit struct {
 	ClaimName string                  `json:"claimName" protobuf:"bytes,1,opt,name=claimName"`
 	Resources v1.ResourceRequirements `json:"resources,omitempty" protobuf:"bytes,2,name=resources"`
 }
+
+type VolumeSplitStatus struct {
+	// Attachments holds the attachment of the volume per node
+	Attachments []VolumeSplitAttachment `json:"attachments,omitempty" protobuf:"bytes,1,rep,name=attachments"`
+}
+
+type AttachmentState string
+
+const (
+	AttachmentStateAttaching AttachmentState = "Attaching"
+	AttachmentStateAttached  AttachmentState = "Attached"
+	AttachmentStateDetaching AttachmentState = "Detaching"
+	AttachmentStateDetached  AttachmentState = "Detached"
+)
+
+type VolumeSplitAttachment struct {
+	NodeName string `json:"nodeName" protobuf:"bytes,1,opt,name=nodeName"`
+	// DesiredState is Attached or Detached
+	DesiredState AttachmentState `json:"desiredState" protobuf:"bytes,2,opt,name=desiredState,casttype=AttachmentState"`
+	// ActualState is the state the attach backend last reported
+	ActualState AttachmentState `json:"actualState" protobuf:"bytes,3,opt,name=actualState,casttype=AttachmentState"`
+	// HostPath and PodPath are where the union mount is served on the node, if the attach backend serves one
+	HostPath string `json:"hostPath,omitempty" protobuf:"bytes,4,opt,name=hostPath"`
+	PodPath  string `json:"podPath,omitempty" protobuf:"bytes,5,opt,name=podPath"`
+	// Message is the last error of the attach backend, if any
+	Message            string      `json:"message,omitempty" protobuf:"bytes,6,opt,name=message"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty" protobuf:"bytes,7,opt,name=lastTransitionTime"`
+}
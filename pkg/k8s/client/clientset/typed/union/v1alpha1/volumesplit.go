@@ -17,6 +17,9 @@ type VolumeSplitInterface interface {
 	Create(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.CreateOptions) (*v1alpha1.VolumeSplit, error)
 	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
 	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.VolumeSplit, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.VolumeSplitList, error)
+	Update(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.UpdateOptions) (*v1alpha1.VolumeSplit, error)
+	UpdateStatus(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.UpdateOptions) (*v1alpha1.VolumeSplit, error)
 }
 
 type volumeSplits struct {
@@ -40,6 +43,16 @@ func (c *volumeSplits) Get(ctx context.Context, name string, options metav1.GetO
 	return
 }
 
+func (c *volumeSplits) List(ctx context.Context, opts metav1.ListOptions) (result *v1alpha1.VolumeSplitList, err error) {
+	result = &v1alpha1.VolumeSplitList{}
+	err = c.client.Get().
+		Resource("volumesplits").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 func (c *volumeSplits) Create(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.CreateOptions) (result *v1alpha1.VolumeSplit, err error) {
 	result = &v1alpha1.VolumeSplit{}
 	err = c.client.Post().
@@ -59,3 +72,28 @@ func (c *volumeSplits) Delete(ctx context.Context, name string, opts metav1.Dele
 		Do(ctx).
 		Error()
 }
+
+func (c *volumeSplits) Update(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.UpdateOptions) (result *v1alpha1.VolumeSplit, err error) {
+	result = &v1alpha1.VolumeSplit{}
+	err = c.client.Put().
+		Resource("volumesplits").
+		Name(volumeSplit.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(volumeSplit).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+func (c *volumeSplits) UpdateStatus(ctx context.Context, volumeSplit *v1alpha1.VolumeSplit, opts metav1.UpdateOptions) (result *v1alpha1.VolumeSplit, err error) {
+	result = &v1alpha1.VolumeSplit{}
+	err = c.client.Put().
+		Resource("volumesplits").
+		Name(volumeSplit.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(volumeSplit).
+		Do(ctx).
+		Into(result)
+	return
+}
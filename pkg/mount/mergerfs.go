@@ -0,0 +1,130 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	klog "k8s.io/klog/v2"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+	mergerfs "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger/mergerfs"
+)
+
+const (
+	// The filesystem type of mergerfs mounts as found in /proc/mounts
+	mergerfsFsType = "fuse.mergerfs"
+	// The prefix of the fsname of union mounts, the device they are found mounted from in /proc/mounts
+	fsNamePrefix = "union-"
+)
+
+// MakeFsName returns union-<volumeId>, the fsname that marks the union mount of volumeId.
+func MakeFsName(volumeId string) string {
+	return fsNamePrefix + volumeId
+}
+
+// MakeFsNameOption returns the mergerfs option that marks a union mount as the one of volumeId.
+func MakeFsNameOption(volumeId string) string {
+	return "fsname=" + MakeFsName(volumeId)
+}
+
+// Merge serves the union mount of branches at target with mergerfs, passing it options.
+// Used by the node plugin to merge lower volumes on the host (node attach backend),
+// so the mergerfs process lives as long as the node plugin container.
+func (m *mounter) Merge(branches []string, target string, options []string) error {
+	for _, branch := range branches {
+		if err := pathExistsAndHealthy(branch); err != nil {
+			return fmt.Errorf("branch %v", err)
+		}
+	}
+
+	isMergerfs, err := m.IsMergerfsMountPoint(target)
+	if err != nil {
+		return err
+	}
+	if isMergerfs {
+		klog.Infof("Target %s is already a union mount", target)
+		return nil
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory %s: %v", target, err)
+	}
+
+	if err := mergerfs.NewMergerfs().Merge(branches, target, options); err != nil {
+		return fmt.Errorf("failed to merge branches %q at %s: %v", branches, target, err)
+	}
+	klog.Infof("Merged branches %q at target %s", branches, target)
+
+	return nil
+}
+
+// IsMergerfsMountPoint checks if path is a mergerfs mount.
+func (m *mounter) IsMergerfsMountPoint(path string) (bool, error) {
+	mps, err := m.List()
+	if err != nil {
+		return false, err
+	}
+
+	// If path is mounted more than once, the last mount is the one in effect.
+	isMergerfs := false
+	for i := range mps {
+		if mps[i].Path == path {
+			isMergerfs = mps[i].Type == mergerfsFsType
+		}
+	}
+
+	return isMergerfs, nil
+}
+
+// RefreshBranches sets the branches of the mergerfs mount at path to their current value,
+// so that mergerfs re-evaluates them, e.g. after a branch was expanded or remounted.
+func (m *mounter) RefreshBranches(path string) error {
+	if err := mergerfs.NewMergerfs().RefreshBranches(path); err != nil {
+		return err
+	}
+	klog.Infof("Refreshed branches of union mount %s", path)
+	return nil
+}
+
+// ListBranches returns the branch paths of the mergerfs mount at target, in order.
+func (m *mounter) ListBranches(target string) ([]string, error) {
+	merged, err := mergerfs.NewMergerfs().ListBranches(target)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, len(merged))
+	for i := range merged {
+		branches[i] = merged[i].Path
+	}
+	return branches, nil
+}
+
+// AddBranches appends the branches that are not yet merged to the mergerfs mount at target, in order,
+// e.g. the lower volumes of an expanded volume.
+func (m *mounter) AddBranches(branches []string, target string) error {
+	mfs := mergerfs.NewMergerfs()
+
+	merged, err := mfs.ListBranches(target)
+	if err != nil {
+		return err
+	}
+	isMerged := map[string]bool{}
+	for _, branch := range merged {
+		isMerged[branch.Path] = true
+	}
+
+	for _, branch := range branches {
+		if isMerged[filepath.Clean(branch)] {
+			continue
+		}
+		if err := pathExistsAndHealthy(branch); err != nil {
+			return fmt.Errorf("branch %v", err)
+		}
+		if err := mfs.AddBranch(target, merger.Branch{Path: branch, Mode: merger.BranchModeRW}, false); err != nil {
+			return err
+		}
+		klog.Infof("Added branch %s to union mount %s", branch, target)
+	}
+	return nil
+}
@@ -1,26 +1,79 @@
 package mount
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	klog "k8s.io/klog/v2"
 	mountutils "k8s.io/mount-utils"
 )
 
 type Mounter interface {
-	Publish(string, string, *PublishOptions) error
+	Stage(string, string) error
+	StageFromPod(string, string) error
+	Unstage(string) error
+	Publish(context.Context, string, string, *PublishOptions) error
 	Unpublish(string) error
+	GetVolumeStats(string) (*VolumeStats, error)
+	GetBranchStats(string) ([]BranchStats, error)
+	RefreshBranches(string) error
+	ListBranches(string) ([]string, error)
+	AddBranches([]string, string) error
+	Merge([]string, string, []string) error
+	IsMergerfsMountPoint(string) (bool, error)
+	UnmountCorrupted(string) error
+	Unmount(string) error
+	ListMounts(string) ([]string, error)
+	GetMountRefs(string) ([]string, error)
 }
 
 type PublishOptions struct {
 	FsType       string
 	ReadOnly     bool
 	MountOptions []string
+	// MultiConsumer allows source to be published at more than one target.
+	MultiConsumer bool
+	// IgnoredRefs are mount references of source that are not targets,
+	// e.g. the path source was staged from.
+	IgnoredRefs []string
+	// VolumeId is the volume source is expected to be the union mount of.
+	// Sources are not checked for their volume if empty.
+	VolumeId string
+}
+
+// Mounter errors that can relate to gRPC error codes
+var (
+	ErrSourceInUse        = errors.New("source is published at another target")
+	ErrTargetIncompatible = errors.New("target is already published with incompatible options")
+	ErrSourceMismatch     = errors.New("source is not the union mount of the volume")
+	ErrSourceNotReady     = errors.New("source is not mounted yet")
+)
+
+const (
+	// How long and how often Publish waits for source to be mounted, e.g. the attach pod is still mounting it
+	sourceWaitTimeout  = 10 * time.Second
+	sourcePollInterval = 500 * time.Millisecond
+)
+
+// Mount flags that show up as is in the options of mounts in /proc/mounts,
+// the ones targets can be checked for when already published.
+var reportedMountFlags = map[string]bool{
+	"nosuid":      true,
+	"nodev":       true,
+	"noexec":      true,
+	"noatime":     true,
+	"nodiratime":  true,
+	"relatime":    true,
+	"strictatime": true,
+	"sync":        true,
+	"dirsync":     true,
 }
 
 type mounter struct {
@@ -33,40 +86,86 @@ func NewMounter() *mounter {
 	return &mounter{mountutils.New("")}
 }
 
-func (m *mounter) Publish(source, target string, options *PublishOptions) error {
-	// First check that source path exists and is a healthy mountpoint.
-	// Whould be nice to also get device name and rest mountpoint references.
-	// k8s.io/mount-utils GetMountRefs does all that except returning the device name.
-	// However, it gives us no way to distinguish between source not existing, source being a corrupted mountpoint,
-	// and source existing, being healthy and just having no rest references since it returns []string, nil in every case described.
-	// Lead with our own handling of not PathExists and IsCorruptedMnt and follow with GetMountRefs.
-	// GetMountRefs also returns error if an entry for source does not exist on /proc/self/mountinfo.
-	// If device name if useful/necessary, we can write a modified GetMountRefs in the future.
-	exists, err := mountutils.PathExists(source)
-	if err != nil {
-		if mountutils.IsCorruptedMnt(err) {
-			return fmt.Errorf("source %s is corrupted: %v", source, err)
+// Stage bind-mounts source at the staging target, which becomes the per-node mount of the volume
+// that targets are published from. Source is the union mount served on the host by the attach pod.
+func (m *mounter) Stage(source, target string) error {
+	if err := pathExistsAndHealthy(source); err != nil {
+		return fmt.Errorf("source %v", err)
+	}
+
+	isMnt, err := m.IsMountPoint(target)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		if !mountutils.IsCorruptedMnt(err) {
+			return fmt.Errorf("error checking if staging target %s is mounted: %v", target, err)
+		}
+		// The union mount behind the staging target went away, e.g. attach pod was recreated.
+		// Clean up and stage again from the healthy source.
+		klog.Infof("Staging target %s is corrupted, attempting to clean up and mount", target)
+		if err := m.cleanupMountPoint(target); err != nil {
+			return fmt.Errorf("failed to clean up staging target %s: %v", target, err)
 		}
-		return fmt.Errorf("error checking if source %s exists: %v", source, err)
+		isMnt = false
 	}
-	if !exists {
-		return fmt.Errorf("source %s does not exist", source)
+
+	if isMnt {
+		refs, err := m.GetMountRefs(target)
+		if err != nil {
+			return fmt.Errorf("error checking mount references of staging target %s: %v", target, err)
+		}
+		for _, ref := range refs {
+			if ref == source {
+				klog.Infof("Staging target %s already mounted by source %s", target, source)
+				return nil
+			}
+		}
+		return fmt.Errorf("staging target %s is already mounted by a different device, will not attempt to mount", target)
 	}
 
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create staging target directory %s: %v", target, err)
+	}
+
+	if err := m.Mount(source, target, "", []string{"bind"}); err != nil {
+		return err
+	}
+	klog.Infof("Mounted source %s at staging target %s", source, target)
+
+	return nil
+}
+
+func (m *mounter) Unstage(target string) error {
+	return m.cleanupMountPoint(target)
+}
+
+// Publish bind-mounts source, the staged union mount of a volume, at target.
+// Before that, it checks that source is the union mount of options.VolumeId, waiting a while for it to be mounted
+// unless ctx is done first, and that target, if already published, was published from source with the same options.
+func (m *mounter) Publish(ctx context.Context, source, target string, options *PublishOptions) error {
+	sourceMnt, err := m.waitForSource(ctx, source)
+	if err != nil {
+		return err
+	}
+	if err := checkSource(source, sourceMnt, options.VolumeId); err != nil {
+		return err
+	}
+
+	// GetMountRefs returns the rest of the mount points of the device of source, bind-mounts included.
 	refs, err := m.GetMountRefs(source)
 	if err != nil {
-		// Could just be that source is not mounted, a more suitable error should be produced then.
-		return fmt.Errorf("error checking if source %s is mounted: %v", source, err)
+		return fmt.Errorf("error checking mount references of source %s: %v", source, err)
 	}
+	refs = filterRefs(refs, options.IgnoredRefs)
 
 	// See how many mount references there are for source.
 	// Is this a good way to determine if source is about to mount/has been mounted on more than 1 target
 	// and make a case about multi-consumer volume capability support?
-	switch l := len(refs); {
-	case l == 0: // source is the only mountpoint of device.
-	case l == 1 && refs[0] == target: // device is mounted at target.
-	default:
-		return fmt.Errorf("found more than one mount reference to source %s that is not target %s: %v", source, target, refs)
+	if !options.MultiConsumer {
+		switch l := len(refs); {
+		case l == 0: // source is the only mountpoint of device.
+		case l == 1 && refs[0] == target: // device is mounted at target.
+		default:
+			return fmt.Errorf("%w: found more than one mount reference to source %s that is not target %s: %v", ErrSourceInUse, source, target, refs)
+		}
 	}
 
 	// Check if target is mounted by the same device as source.
@@ -78,25 +177,27 @@ func (m *mounter) Publish(source, target string, options *PublishOptions) error
 		}
 	}
 
-	// Target is mounted by the same device as source.
+	// Target is mounted by the same device as source, which checkSource tied to the volume.
 	if isMnt {
-		// We should somehow check that the device is indeed backed by the volume of volumeId
-		// and is idempotent-compatible with requested VolumeCapability and O fields.
-		// Assume it is for now and return nil error.
-		//
-		// Also also, source is mounted by device, target is mounted by device, source is healthy => target is healthy, right?
-		klog.InfoS("Target %s already mounted by source %s", target, source)
+		if err := m.checkTarget(target, options); err != nil {
+			return err
+		}
+		klog.Infof("Target %s already mounted by source %s", target, source)
 		return nil
 	}
 
 	// Check if target is mounted by a filesystem other than device of source.
 	isMnt, err = m.IsMountPoint(target)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		if mountutils.IsCorruptedMnt(err) {
-			// If target is corrupted, should we attempt to unmount, remove, make and mount again?
-			return fmt.Errorf("target %s is corrupted, will not attempt to mount: %v", target, err)
+		if !mountutils.IsCorruptedMnt(err) {
+			return fmt.Errorf("error checking if target %s is mounted: %v", target, err)
 		}
-		return fmt.Errorf("error checking if target %s is mounted: %v", target, err)
+		// Source is healthy, so target was published from a union mount that went away. Publish it again.
+		klog.Infof("Target %s is corrupted, attempting to clean up and mount", target)
+		if err := m.cleanupMountPoint(target); err != nil {
+			return fmt.Errorf("failed to clean up target %s: %v", target, err)
+		}
+		isMnt = false
 	}
 
 	// Target is mounted by a diffrent device than source.
@@ -132,6 +233,11 @@ func (m *mounter) Publish(source, target string, options *PublishOptions) error
 }
 
 func (m *mounter) Unpublish(target string) error {
+	return m.cleanupMountPoint(target)
+}
+
+// cleanupMountPoint unmounts target and removes its directory.
+func (m *mounter) cleanupMountPoint(target string) error {
 	if err := mountutils.CleanupMountPoint(target, m, true); err != nil {
 		// See: https://github.com/kubernetes-sigs/aws-ebs-csi-driver/blob/v1.21.0/pkg/driver/mount_linux.go#L146C1-L150C68
 		if strings.Contains(fmt.Sprint(err), "not mounted") {
@@ -144,7 +250,162 @@ func (m *mounter) Unpublish(target string) error {
 	return nil
 }
 
-/*func pathExistsAndHealthy(path string) error {
+// filterRefs returns refs without the ones found in ignored.
+func filterRefs(refs, ignored []string) []string {
+	if len(ignored) == 0 {
+		return refs
+	}
+	filtered := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		keep := true
+		for _, i := range ignored {
+			if ref == i {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			filtered = append(filtered, ref)
+		}
+	}
+	return filtered
+}
+
+// waitForSource waits for source to be a healthy mount point and returns its mount.
+// It returns ErrSourceNotReady once sourceWaitTimeout passes, or the error of ctx once it is done.
+func (m *mounter) waitForSource(ctx context.Context, source string) (*mountutils.MountPoint, error) {
+	deadline := time.NewTimer(sourceWaitTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(sourcePollInterval)
+	defer ticker.Stop()
+
+	for {
+		mnt, err := m.getMountPoint(source)
+		if err == nil && mnt == nil {
+			err = fmt.Errorf("source %s is not mounted", source)
+		}
+		if err == nil {
+			if err = pathExistsAndHealthy(source); err != nil {
+				err = fmt.Errorf("source %v", err)
+			}
+		}
+		if err == nil {
+			return mnt, nil
+		}
+		klog.V(4).Infof("Waiting for source %s: %v", source, err)
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return nil, fmt.Errorf("%w: %v", ErrSourceNotReady, err)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for source %s: %w", source, ctx.Err())
+		}
+	}
+}
+
+// checkSource checks that mnt, the mount of source, is a union mount, of volumeId if set.
+// Union mounts without a fsname marking their volume, e.g. served by earlier attach pods, are not checked for it.
+func checkSource(source string, mnt *mountutils.MountPoint, volumeId string) error {
+	if mnt.Type != mergerfsFsType {
+		return fmt.Errorf("%w: source %s is a %s mount, expected %s", ErrSourceMismatch, source, mnt.Type, mergerfsFsType)
+	}
+	if volumeId == "" || !strings.HasPrefix(mnt.Device, fsNamePrefix) {
+		return nil
+	}
+	if fsName := MakeFsName(volumeId); mnt.Device != fsName {
+		return fmt.Errorf("%w: source %s is mounted from %s, expected %s", ErrSourceMismatch, source, mnt.Device, fsName)
+	}
+	return nil
+}
+
+// checkTarget checks that target is mounted with the readonly flag and the mount flags of options
+// that can be told from /proc/mounts. The rest of the options cannot be told from the mount table.
+func (m *mounter) checkTarget(target string, options *PublishOptions) error {
+	mnt, err := m.getMountPoint(target)
+	if err != nil {
+		return fmt.Errorf("error checking mount options of target %s: %v", target, err)
+	}
+	if mnt == nil {
+		return fmt.Errorf("target %s is not a mountpoint", target)
+	}
+
+	opts := make(map[string]bool, len(mnt.Opts))
+	for _, opt := range mnt.Opts {
+		opts[opt] = true
+	}
+	if readOnly := opts["ro"]; readOnly != options.ReadOnly {
+		return fmt.Errorf("%w: target %s is mounted with readonly %t, requested %t", ErrTargetIncompatible, target, readOnly, options.ReadOnly)
+	}
+	for _, flag := range options.MountOptions {
+		if reportedMountFlags[flag] && !opts[flag] {
+			return fmt.Errorf("%w: target %s is mounted without %s, mounted with %v", ErrTargetIncompatible, target, flag, mnt.Opts)
+		}
+	}
+	return nil
+}
+
+// getMountPoint returns the mount in effect at path, nil if path is not a mountpoint.
+func (m *mounter) getMountPoint(path string) (*mountutils.MountPoint, error) {
+	mps, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	// If path is mounted more than once, the last mount is the one in effect.
+	var mnt *mountutils.MountPoint
+	for i := range mps {
+		if mps[i].Path == path {
+			mnt = &mps[i]
+		}
+	}
+	return mnt, nil
+}
+
+// UnmountCorrupted unmounts the corrupted mounts stacked at path and leaves its directory in place,
+// e.g. for a restarting attach pod to serve the union mount at path again.
+func (m *mounter) UnmountCorrupted(path string) error {
+	for IsCorrupted(path) {
+		if err := m.Unmount(path); err != nil {
+			return fmt.Errorf("failed to unmount corrupted %s: %v", path, err)
+		}
+		klog.Infof("Unmounted corrupted %s", path)
+	}
+	return nil
+}
+
+// ListMounts returns the mount points at or under dir as listed in /proc/self/mountinfo, deepest first,
+// so that they can be unmounted in order. Stacked mounts are listed once per mount.
+func (m *mounter) ListMounts(dir string) ([]string, error) {
+	mountInfos, err := mountutils.ParseMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	dir = filepath.Clean(dir)
+	var mounts []string
+	for _, mountInfo := range mountInfos {
+		if mountInfo.MountPoint == dir || strings.HasPrefix(mountInfo.MountPoint, dir+"/") {
+			mounts = append(mounts, mountInfo.MountPoint)
+		}
+	}
+	// Later mounts are stacked on top of or nested in earlier ones
+	for i, j := 0, len(mounts)-1; i < j; i, j = i+1, j-1 {
+		mounts[i], mounts[j] = mounts[j], mounts[i]
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i], "/") > strings.Count(mounts[j], "/")
+	})
+	return mounts, nil
+}
+
+// IsCorrupted checks if path is a corrupted mount point, e.g. the mergerfs process behind it is gone.
+func IsCorrupted(path string) bool {
+	_, err := mountutils.PathExists(path)
+	return err != nil && mountutils.IsCorruptedMnt(err)
+}
+
+func pathExistsAndHealthy(path string) error {
 	exists, err := mountutils.PathExists(path)
 	if err != nil {
 		if mountutils.IsCorruptedMnt(err) {
@@ -156,7 +417,7 @@ func (m *mounter) Unpublish(target string) error {
 		return fmt.Errorf("%s does not exist", path)
 	}
 	return nil
-}*/
+}
 
 // **********
 
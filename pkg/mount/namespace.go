@@ -0,0 +1,78 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	klog "k8s.io/klog/v2"
+	mountutils "k8s.io/mount-utils"
+)
+
+// StageFromPod bind-mounts the union mount served at podPath inside the mount namespace of an
+// unprivileged attach pod at the staging target.
+// Unprivileged containers cannot propagate mounts back to the host, so the union mount is reached
+// through /proc/<pid>/root of a process in the pod, which requires the host PID namespace.
+func (m *mounter) StageFromPod(podPath, target string) error {
+	isMergerfs, err := m.IsMergerfsMountPoint(target)
+	if err != nil {
+		return fmt.Errorf("error checking if staging target %s is mounted: %v", target, err)
+	}
+	if isMergerfs {
+		if err := pathExistsAndHealthy(target); err == nil {
+			klog.Infof("Staging target %s is already a union mount", target)
+			return nil
+		}
+		// The attach pod was recreated, clean up and stage again from the new one.
+		klog.Infof("Staging target %s is corrupted, attempting to clean up and mount", target)
+		if err := m.cleanupMountPoint(target); err != nil {
+			return fmt.Errorf("failed to clean up staging target %s: %v", target, err)
+		}
+	}
+
+	pid, err := findMergerfsProcess(podPath)
+	if err != nil {
+		return err
+	}
+	source := filepath.Join("/proc", strconv.Itoa(pid), "root", podPath)
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create staging target directory %s: %v", target, err)
+	}
+
+	if err := m.Mount(source, target, "", []string{"bind"}); err != nil {
+		return err
+	}
+	klog.Infof("Mounted union mount %s of process %d at staging target %s", podPath, pid, target)
+
+	return nil
+}
+
+// findMergerfsProcess returns the PID of a process whose mount namespace has a mergerfs mount at mountPath.
+func findMergerfsProcess(mountPath string) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		// Processes can exit while iterating, skip whatever cannot be read.
+		mountInfos, err := mountutils.ParseMountInfo(filepath.Join("/proc", entry.Name(), "mountinfo"))
+		if err != nil {
+			continue
+		}
+		for _, mi := range mountInfos {
+			if mi.MountPoint == mountPath && mi.FsType == mergerfsFsType {
+				return pid, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no process found with a union mount at %s, is the node plugin running in the host PID namespace (see deploy/k8s/unprivileged)?", mountPath)
+}
@@ -0,0 +1,68 @@
+package mount
+
+import (
+	"errors"
+	"os"
+
+	unix "golang.org/x/sys/unix"
+)
+
+// VolumeStats holds the byte and inode usage of a mounted filesystem.
+type VolumeStats struct {
+	AvailableBytes int64
+	TotalBytes     int64
+	UsedBytes      int64
+
+	AvailableInodes int64
+	TotalInodes     int64
+	UsedInodes      int64
+}
+
+// BranchStats holds the usage of a branch of a union mount.
+// Err is set if the branch could not be reached.
+type BranchStats struct {
+	Path  string
+	Stats *VolumeStats
+	Err   error
+}
+
+// GetVolumeStats returns the usage of the filesystem mounted at path.
+// For a union mount this is the usage of its branches combined.
+func (m *mounter) GetVolumeStats(path string) (*VolumeStats, error) {
+	statfs := &unix.Statfs_t{}
+	if err := unix.Statfs(path, statfs); err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+
+	return &VolumeStats{
+		AvailableBytes: int64(statfs.Bavail) * int64(statfs.Bsize),
+		TotalBytes:     int64(statfs.Blocks) * int64(statfs.Bsize),
+		UsedBytes:      (int64(statfs.Blocks) - int64(statfs.Bfree)) * int64(statfs.Bsize),
+
+		AvailableInodes: int64(statfs.Ffree),
+		TotalInodes:     int64(statfs.Files),
+		UsedInodes:      int64(statfs.Files) - int64(statfs.Ffree),
+	}, nil
+}
+
+// GetBranchStats returns the usage of each branch of the mergerfs mount at path,
+// as listed in its runtime control file.
+// Branch paths are the paths mergerfs was given by whoever merged them, so branches
+// that do not exist in this mount namespace (e.g. merged inside an attach pod) are skipped.
+func (m *mounter) GetBranchStats(path string) ([]BranchStats, error) {
+	branches, err := m.ListBranches(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []BranchStats
+	for _, branch := range branches {
+		s, err := m.GetVolumeStats(branch)
+		if err != nil && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		stats = append(stats, BranchStats{Path: branch, Stats: s, Err: err})
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,91 @@
+package union
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+
+	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
+)
+
+// ValidateLowerClaimChange checks that oldClaim may be deleted, if newClaim is nil, or updated to newClaim.
+// Lower claims referenced by a live VolumeSplit may not be deleted nor have their spec changed, other than
+// getting bound, unless they are annotated with UnprotectedAnnotationKey. It returns ErrLowerClaimProtected otherwise.
+func (u *union) ValidateLowerClaimChange(ctx context.Context, oldClaim, newClaim *v1.PersistentVolumeClaim) error {
+	if isClaimUnprotected(oldClaim) || (newClaim != nil && isClaimUnprotected(newClaim)) {
+		return nil
+	}
+	if newClaim != nil && isBindingOnlyChange(oldClaim, newClaim) {
+		return nil
+	}
+
+	volumeId, ok := oldClaim.Labels[VolumeLabelKey]
+	if !ok {
+		return nil
+	}
+
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		if errors.Is(err, ErrVolumeNotFound) {
+			return nil
+		}
+		return err
+	}
+	if split.DeletionTimestamp != nil || !isClaimOfSplit(oldClaim, split) {
+		return nil
+	}
+
+	operation := "deleted"
+	if newClaim != nil {
+		operation = "changed"
+	}
+	klog.Infof("Denied lower claim %q of volume %q to be %s", claimToClaimKey(oldClaim), volumeId, operation)
+	return fmt.Errorf("%w: lower claim %q is a branch of volume %q and may not be %s unless annotated with %s=true",
+		ErrLowerClaimProtected, claimToClaimKey(oldClaim), volumeId, operation, UnprotectedAnnotationKey)
+}
+
+// unprotectLowerClaim annotates the lower claim namespace/name with UnprotectedAnnotationKey,
+// for the driver to delete it past the admission webhook. It returns false if the claim does not exist.
+func (u *union) unprotectLowerClaim(ctx context.Context, namespace, name string) (bool, error) {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, UnprotectedAnnotationKey))
+	_, err := u.kubeClient.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func isClaimUnprotected(claim *v1.PersistentVolumeClaim) bool {
+	return claim.Annotations[UnprotectedAnnotationKey] == "true"
+}
+
+// isBindingOnlyChange returns true if newClaim differs from oldClaim in spec only by getting bound to a volume.
+func isBindingOnlyChange(oldClaim, newClaim *v1.PersistentVolumeClaim) bool {
+	oldSpec := oldClaim.Spec.DeepCopy()
+	if oldSpec.VolumeName == "" {
+		oldSpec.VolumeName = newClaim.Spec.VolumeName
+	}
+	return apiequality.Semantic.DeepEqual(oldSpec, &newClaim.Spec)
+}
+
+func isClaimOfSplit(claim *v1.PersistentVolumeClaim, split *v1alpha1.VolumeSplit) bool {
+	if claim.Namespace != split.Spec.Namespace {
+		return false
+	}
+	for i := range split.Spec.Splits {
+		if split.Spec.Splits[i].ClaimName == claim.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -5,18 +5,17 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
-	"path/filepath"
-	"time"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	wait "k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	kubernetes "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	klog "k8s.io/klog/v2"
 
-	pod "github.com/on2e/union-csi-driver/pkg/union/pod"
+	podutil "github.com/on2e/union-csi-driver/pkg/union/pod"
 )
 
 // attacher implements the Attacher interface.
@@ -27,24 +26,38 @@ import (
 // back on the host via a HostPath volume.
 // Note that this union mount, now accessible on the node for subsequent bind-mounts on consumer
 // containers, is the storage asset behind a Union PersistentVolume.
+// Single-node volumes have one attach pod per volume. Multi-node volumes have one attach pod
+// per volume and node, so each node attachment is created and deleted independently.
 type attacher struct {
 	kubeClient kubernetes.Interface
 	podLister  corelisters.PodLister
-	podFactory *pod.Factory
+	podFactory *podutil.Factory
+	// podWaiters wakes up waiting for attach pods on their events
+	podWaiters *podWaiters
+	// recorder records the reasons attach pods fail
+	recorder *volumeRecorder
+	// backoff of checking attach pods between their events
+	backoff wait.Backoff
+	// stateDir is where attach pods serve union mounts on the host
+	stateDir string
 }
 
 var _ Attacher = &attacher{}
 
-func NewAttacher(kubeClient kubernetes.Interface, podLister corelisters.PodLister) *attacher {
+func NewAttacher(kubeClient kubernetes.Interface, podInformer coreinformers.PodInformer, podFactory *podutil.Factory, recorder *volumeRecorder, backoff wait.Backoff, stateDir string) *attacher {
 	return &attacher{
 		kubeClient: kubeClient,
-		podLister:  podLister,
-		podFactory: pod.NewFactory(),
+		podLister:  podInformer.Lister(),
+		podFactory: podFactory,
+		podWaiters: newPodWaiters(podInformer),
+		recorder:   recorder,
+		backoff:    backoff,
+		stateDir:   stateDir,
 	}
 }
 
 func (a *attacher) Attach(ctx context.Context, volume *Volume, nodeId string) (*VolumeAttachment, error) {
-	podName := makeAttachPodName(volume.VolumeId)
+	podName := makeAttachPodNameForVolume(volume, nodeId)
 	podKey := volume.Namespace + "/" + podName
 
 	pod, err := a.podLister.Pods(volume.Namespace).Get(podName)
@@ -52,13 +65,36 @@ func (a *attacher) Attach(ctx context.Context, volume *Volume, nodeId string) (*
 		return nil, fmt.Errorf("error getting pod %q: %v", podKey, err)
 	}
 
-	hostPath := makeHostPath(volume.VolumeId)
+	// A failed attach pod, e.g. evicted, never serves the union mount again. Replace it.
+	if pod != nil && isPodTerminating(pod) && pod.DeletionTimestamp == nil && (pod.Spec.NodeName == "" || pod.Spec.NodeName == nodeId) {
+		klog.Infof("Attach pod %q for volume %q has phase %s (%s), deleting it to create a new one", podKey, volume.VolumeId, pod.Status.Phase, pod.Status.Reason)
+		err := a.kubeClient.CoreV1().Pods(volume.Namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error deleting pod %q: %v", podKey, err)
+		}
+		if err := a.waitForDetach(ctx, volume.VolumeId, pod.Spec.NodeName, podName, volume.Namespace); err != nil {
+			return nil, err
+		}
+		pod = nil
+	}
 
 	if pod == nil {
+		hostPath := MakeMergedDir(a.stateDir, volume.VolumeId)
+
 		// Create a new attach pod
-		pod = a.podFactory.Create(podName, volume.Namespace, volume.ClaimNames, hostPath, volume.VolumeId)
+		pod, err = a.podFactory.Create(podName, volume.Namespace, volume.ClaimNames, hostPath, volume.VolumeId, volume.Generation, volume.AttachPodTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("error creating attach pod %q for volume %q: %v", podKey, volume.VolumeId, err)
+		}
 		// TODO: find the right place and mechanism to apply the nodeSelector
 		pod.Spec.NodeSelector = map[string]string{"kubernetes.io/hostname": nodeId}
+		// Label the attach pod with its volume for the controller to attach the volume again if the pod fails or is deleted
+		labels := map[string]string{}
+		for k, v := range pod.Labels {
+			labels[k] = v
+		}
+		labels[VolumeLabelKey] = volume.VolumeId
+		pod.Labels = labels
 
 		_, err = a.kubeClient.CoreV1().Pods(volume.Namespace).Create(ctx, pod, metav1.CreateOptions{})
 		if err == nil {
@@ -73,25 +109,19 @@ func (a *attacher) Attach(ctx context.Context, volume *Volume, nodeId string) (*
 	}
 
 	klog.Infof("Start waiting for attachment of volume %q at node %q", volume.VolumeId, nodeId)
-	attachment, err := a.waitForAttach(ctx, volume.VolumeId, nodeId, podName, volume.Namespace)
-	if attachment != nil {
-		// NOTE: this should be better detected and set in waitForAttach
-		attachment.HostPath = hostPath
-	}
-	return attachment, err
+	return a.waitForAttach(ctx, volume, nodeId, podName)
 }
 
-func (a *attacher) waitForAttach(ctx context.Context, volumeId, expectedNodeId, podName, podNamespace string) (*VolumeAttachment, error) {
+func (a *attacher) waitForAttach(ctx context.Context, volume *Volume, expectedNodeId, podName string) (*VolumeAttachment, error) {
 	var pod *v1.Pod
 	var err error
+	// failure is the last reason found for the attach pod not being Ready
+	var failure *attachFailure
+
+	volumeId := volume.VolumeId
+	podNamespace := volume.Namespace
 
 	podKey := podNamespace + "/" + podName
-	// Semi-random values
-	backoff := wait.Backoff{
-		Duration: 1 * time.Second,
-		Factor:   1.5,
-		Steps:    12,
-	}
 
 	waitForAttachFunc := func(ctx context.Context) (bool, error) {
 		pod, err = a.podLister.Pods(podNamespace).Get(podName)
@@ -102,17 +132,25 @@ func (a *attacher) waitForAttach(ctx context.Context, volumeId, expectedNodeId,
 			// Pod may have not made it in local cache yet, return nil error to continue waiting
 			return false, nil
 		}
-		// NOTE: can also embed pod status reason and message fields on "pod not running yet" errors/logs
 
 		// If pod is terminating, stop waiting for it with an error
 		if isPodTerminating(pod) {
 			klog.Infof("Attach pod %q for volume %q is terminating, stop waiting for attachment", podKey, volumeId)
+			if f := diagnosePodContainers(pod); f != nil {
+				a.recordFailure(volume, expectedNodeId, f)
+				return false, fmt.Errorf("attach pod %q for volume %q is terminating: %w", podKey, volumeId, f)
+			}
 			return false, fmt.Errorf("attach pod %q for volume %q is terminating", podKey, volumeId)
 		}
 
 		// If pod is not running on a node yet, return nil error to continue waiting
 		if !isPodRunning(pod) {
-			klog.Infof("Attach pod %q for volume %q is not running on a node yet, continue waiting for attachment ...", podKey, volumeId)
+			if f := a.diagnoseAttachPod(ctx, pod); f != nil {
+				klog.Infof("Attach pod %q for volume %q is not running on a node yet (%s: %s), continue waiting for attachment ...", podKey, volumeId, f.reason, f.message)
+				failure = a.updateFailure(volume, expectedNodeId, failure, f)
+			} else {
+				klog.Infof("Attach pod %q for volume %q is not running on a node yet, continue waiting for attachment ...", podKey, volumeId)
+			}
 			return false, nil
 		}
 
@@ -123,20 +161,73 @@ func (a *attacher) waitForAttach(ctx context.Context, volumeId, expectedNodeId,
 			return true, ErrVolumeInUse
 		}
 
+		// If the union mount is not live yet, i.e. pod is not Ready, return nil error to continue waiting
+		if !isPodReady(pod) {
+			if f := a.diagnoseAttachPod(ctx, pod); f != nil {
+				klog.Infof("Attach pod %q for volume %q is running but its union mount is not ready yet (%s: %s), continue waiting for attachment ...", podKey, volumeId, f.reason, f.message)
+				failure = a.updateFailure(volume, expectedNodeId, failure, f)
+			} else {
+				klog.Infof("Attach pod %q for volume %q is running but its union mount is not ready yet, continue waiting for attachment ...", podKey, volumeId)
+			}
+			return false, nil
+		}
+
 		return true, nil
 	}
 
 	// Consider using google.golang.org/grpc/codes.DeadlineExceeded
-	waitErr := wait.ExponentialBackoffWithContext(ctx, backoff, waitForAttachFunc)
+	waitErr := a.podWaiters.wait(ctx, podNamespace, podName, a.backoff, waitForAttachFunc)
 	if waitErr != nil && !errors.Is(waitErr, ErrVolumeInUse) {
+		// Timed out or cancelled, report why the attach pod did not make it instead
+		if wait.Interrupted(waitErr) && failure != nil {
+			return nil, fmt.Errorf("attach pod %q for volume %q is not ready: %w", podKey, volumeId, failure)
+		}
 		return nil, waitErr
 	}
 
-	return &VolumeAttachment{VolumeId: volumeId, NodeId: pod.Spec.NodeName}, waitErr
+	attachment := &VolumeAttachment{VolumeId: volumeId, NodeId: pod.Spec.NodeName}
+	if waitErr == nil {
+		attachment.HostPath, attachment.PodPath = getAttachPodPaths(pod)
+	}
+
+	return attachment, waitErr
+}
+
+// updateFailure records an Event for f unless it is the same as the last failure found.
+func (a *attacher) updateFailure(volume *Volume, nodeId string, last, f *attachFailure) *attachFailure {
+	if last == nil || last.reason != f.reason || last.message != f.message {
+		a.recordFailure(volume, nodeId, f)
+	}
+	return f
+}
+
+// recordFailure records a Warning Event for f, so users can find out why their volume
+// is not attached without looking for its attach pod.
+func (a *attacher) recordFailure(volume *Volume, nodeId string, f *attachFailure) {
+	a.recorder.Eventf(volume, v1.EventTypeWarning, AttachFailedEventReason, "Attach pod %q at node %q is not ready: %s: %s", makeAttachPodNameForVolume(volume, nodeId), nodeId, f.reason, f.message)
+}
+
+// getAttachPodPaths returns where pod serves the union mount: the host path of its target volume
+// if it propagates the union mount to the host, or the path inside the pod otherwise.
+// Does not check if pod is nil.
+func getAttachPodPaths(pod *v1.Pod) (hostPath, podPath string) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == podutil.TargetVolumeName && volume.HostPath != nil {
+			return volume.HostPath.Path, ""
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, mount := range c.VolumeMounts {
+			if mount.Name == podutil.TargetVolumeName {
+				return "", mount.MountPath
+			}
+		}
+	}
+	return "", ""
 }
 
 func (a *attacher) Detach(ctx context.Context, volume *Volume, nodeId string) error {
-	podName := makeAttachPodName(volume.VolumeId)
+	podName := makeAttachPodNameForVolume(volume, nodeId)
 	podKey := volume.Namespace + "/" + podName
 
 	pod, err := a.podLister.Pods(volume.Namespace).Get(podName)
@@ -150,12 +241,8 @@ func (a *attacher) Detach(ctx context.Context, volume *Volume, nodeId string) er
 		}
 	}
 
-	if len(pod.Spec.NodeName) == 0 {
-		klog.Infof("Attach pod %q for volume %q is not scheduled on a node yet, will not attempt to delete", podKey, volume.VolumeId)
-		return ErrAttachmentNotFound
-	}
-
-	if pod.Spec.NodeName != nodeId {
+	// Pods not scheduled yet are deleted too, they still hold the lower claims.
+	if pod.Spec.NodeName != "" && pod.Spec.NodeName != nodeId {
 		// Currently, assume volume is attached on that other node
 		klog.Infof("Attach pod %q for volume %q found on node %q, expected %q, will not attempt to delete", podKey, volume.VolumeId, pod.Spec.NodeName, nodeId)
 		return ErrAttachmentNotFound
@@ -177,14 +264,21 @@ func (a *attacher) Detach(ctx context.Context, volume *Volume, nodeId string) er
 	return a.waitForDetach(ctx, volume.VolumeId, nodeId, podName, volume.Namespace)
 }
 
+// IsAttached checks if the attach pod of volume exists at node nodeId, or is not scheduled yet.
+func (a *attacher) IsAttached(ctx context.Context, volume *Volume, nodeId string) (bool, error) {
+	podName := makeAttachPodNameForVolume(volume, nodeId)
+	pod, err := a.podLister.Pods(volume.Namespace).Get(podName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting pod \"%s/%s\": %v", volume.Namespace, podName, err)
+	}
+	return pod.Spec.NodeName == "" || pod.Spec.NodeName == nodeId, nil
+}
+
 func (a *attacher) waitForDetach(ctx context.Context, volumeId, expectedNodeId, podName, podNamespace string) error {
 	podKey := podNamespace + "/" + podName
-	// Semi-random values
-	backoff := wait.Backoff{
-		Duration: 1 * time.Second,
-		Factor:   2.0,
-		Steps:    12,
-	}
 
 	waitForDetachFunc := func(ctx context.Context) (bool, error) {
 		pod, err := a.podLister.Pods(podNamespace).Get(podName)
@@ -197,7 +291,7 @@ func (a *attacher) waitForDetach(ctx context.Context, volumeId, expectedNodeId,
 		}
 
 		// We already checked for that in Detach() ...
-		if pod.Spec.NodeName != expectedNodeId {
+		if pod.Spec.NodeName != "" && pod.Spec.NodeName != expectedNodeId {
 			klog.Infof("Attach pod %q for volume %q found on node %q, expected %q, stop waiting for detachment", podKey, volumeId, pod.Spec.NodeName, expectedNodeId)
 			return false, ErrAttachmentNotFound
 		}
@@ -207,7 +301,15 @@ func (a *attacher) waitForDetach(ctx context.Context, volumeId, expectedNodeId,
 		return false, nil
 	}
 
-	return wait.ExponentialBackoffWithContext(ctx, backoff, waitForDetachFunc)
+	return a.podWaiters.wait(ctx, podNamespace, podName, a.backoff, waitForDetachFunc)
+}
+
+// makeAttachPodNameForVolume returns the name of the attach pod of volume at node nodeId.
+func makeAttachPodNameForVolume(volume *Volume, nodeId string) string {
+	if isMultiNodeVolume(volume) {
+		return makeNodeAttachPodName(volume.VolumeId, nodeId)
+	}
+	return makeAttachPodName(volume.VolumeId)
 }
 
 // makeAttachPodName returns attach-pod-<sha256(volumeId)>
@@ -216,10 +318,10 @@ func makeAttachPodName(volumeId string) string {
 	return fmt.Sprintf("attach-pod-%x", result)
 }
 
-// makeHostPath
-func makeHostPath(volumeId string) string {
-	// TODO: make driver provide this dir
-	return filepath.Join("/var/lib/union-csi-driver.union.io/volumes", volumeId, "merged")
+// makeNodeAttachPodName returns attach-pod-<sha256(volumeId/nodeId)>
+func makeNodeAttachPodName(volumeId, nodeId string) string {
+	result := sha256.Sum256([]byte(volumeId + "/" + nodeId))
+	return fmt.Sprintf("attach-pod-%x", result)
 }
 
 // isPodTerminated checks if pod is terminating.
@@ -228,6 +330,17 @@ func isPodTerminating(pod *v1.Pod) bool {
 	return pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded
 }
 
+// isPodReady checks if pod has a true Ready condition.
+// Does not check if pod is nil.
+func isPodReady(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
 // isPodRunning checks if pod has a non-empty NodeName and a Running phase.
 // Does not check if pod is nil.
 func isPodRunning(pod *v1.Pod) bool {
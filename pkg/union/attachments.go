@@ -0,0 +1,89 @@
+package union
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	retry "k8s.io/client-go/util/retry"
+
+	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
+)
+
+/*
+	The attachments of a volume are recorded per node in the status of its VolumeSplit, with the state the
+	CO asked for (desired) and the state the attach backend last reported (actual):
+
+	ControllerPublishVolume:   desired Attached, actual Attaching -> Attached
+	ControllerUnpublishVolume: desired Detached, actual Detaching -> Detached, the record is removed
+
+	A record left Attaching or Detaching means the attach backend failed or the controller went away in between,
+	its message holds the last error. Whether a volume is attached at a node is decided by these records
+	instead of the state of attach pods or VolumeAttachments, which can lag behind or be removed by others.
+*/
+
+// attachmentUpdateFunc updates the attachment of a volume at a node within split.
+// A new attachment is Detached in both desired and actual state.
+type attachmentUpdateFunc func(split *v1alpha1.VolumeSplit, attachment *v1alpha1.VolumeSplitAttachment) error
+
+// updateAttachment updates the attachment of volumeId at nodeId with update, retrying on conflicts,
+// and returns the updated VolumeSplit. Attachments left Detached in both states are removed.
+func (u *union) updateAttachment(ctx context.Context, volumeId, nodeId string, update attachmentUpdateFunc) (*v1alpha1.VolumeSplit, error) {
+	var split *v1alpha1.VolumeSplit
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		split, err = u.splitter.GetSplit(ctx, volumeId)
+		if err != nil {
+			return err
+		}
+
+		i := findAttachment(split, nodeId)
+		if i < 0 {
+			split.Status.Attachments = append(split.Status.Attachments, v1alpha1.VolumeSplitAttachment{
+				NodeName:     nodeId,
+				DesiredState: v1alpha1.AttachmentStateDetached,
+				ActualState:  v1alpha1.AttachmentStateDetached,
+			})
+			i = len(split.Status.Attachments) - 1
+		}
+
+		attachment := &split.Status.Attachments[i]
+		old := *attachment
+		if err := update(split, attachment); err != nil {
+			return err
+		}
+		if attachment.DesiredState != old.DesiredState || attachment.ActualState != old.ActualState {
+			attachment.LastTransitionTime = metav1.Now()
+		}
+
+		if attachment.DesiredState == v1alpha1.AttachmentStateDetached && attachment.ActualState == v1alpha1.AttachmentStateDetached {
+			split.Status.Attachments = append(split.Status.Attachments[:i], split.Status.Attachments[i+1:]...)
+		}
+
+		split, err = u.splitter.UpdateSplitStatus(ctx, split)
+		return err
+	})
+
+	return split, err
+}
+
+// findAttachment returns the index of the attachment at nodeId in the status of split, -1 if there is none.
+func findAttachment(split *v1alpha1.VolumeSplit, nodeId string) int {
+	for i := range split.Status.Attachments {
+		if split.Status.Attachments[i].NodeName == nodeId {
+			return i
+		}
+	}
+	return -1
+}
+
+// findOtherAttachment returns an attachment of split at a node other than nodeId that is not Detached, if any.
+func findOtherAttachment(split *v1alpha1.VolumeSplit, nodeId string) *v1alpha1.VolumeSplitAttachment {
+	for i := range split.Status.Attachments {
+		attachment := &split.Status.Attachments[i]
+		if attachment.NodeName != nodeId && attachment.ActualState != v1alpha1.AttachmentStateDetached {
+			return attachment
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package union
+
+import (
+	"context"
+	"fmt"
+
+	labels "k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// The label of the pods of the gogomergerfs daemon DaemonSet
+	daemonPodLabelKey   = "app.kubernetes.io/name"
+	daemonPodLabelValue = "union-mergerfs-daemon"
+)
+
+// daemonAttacher implements the Attacher interface for the daemon attach backend.
+// Lower volumes are attached to the node same as nodeAttacher does, but instead of one mergerfs
+// per volume in an attach pod or the node plugin, a single gogomergerfs daemon per node serves the
+// union mounts of all volumes on that node, keyed by volume ID.
+// The node plugin relays merge/unmerge requests to the daemon over its unix domain socket at
+// NodeStageVolume/NodeUnstageVolume, since the daemon is not reachable from the controller.
+type daemonAttacher struct {
+	*nodeAttacher
+	podLister corelisters.PodLister
+}
+
+var _ Attacher = &daemonAttacher{}
+
+func NewDaemonAttacher(nodeAttacher *nodeAttacher, podLister corelisters.PodLister) *daemonAttacher {
+	return &daemonAttacher{
+		nodeAttacher: nodeAttacher,
+		podLister:    podLister,
+	}
+}
+
+func (a *daemonAttacher) Attach(ctx context.Context, volume *Volume, nodeId string) (*VolumeAttachment, error) {
+	// Fail early instead of leaving NodeStageVolume to time out on a missing socket.
+	if err := a.checkDaemonRunning(nodeId); err != nil {
+		return nil, err
+	}
+	return a.nodeAttacher.Attach(ctx, volume, nodeId)
+}
+
+// checkDaemonRunning checks that a gogomergerfs daemon pod is running on node nodeId.
+func (a *daemonAttacher) checkDaemonRunning(nodeId string) error {
+	selector := labels.SelectorFromSet(labels.Set{daemonPodLabelKey: daemonPodLabelValue})
+	pods, err := a.podLister.List(selector)
+	if err != nil {
+		return fmt.Errorf("error listing gogomergerfs daemon pods: %v", err)
+	}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == nodeId && isPodRunning(pod) && isPodReady(pod) {
+			return nil
+		}
+	}
+
+	klog.Infof("No gogomergerfs daemon pod is running at node %q", nodeId)
+	return fmt.Errorf("no gogomergerfs daemon pod (%s=%s) is running at node %q", daemonPodLabelKey, daemonPodLabelValue, nodeId)
+}
@@ -0,0 +1,134 @@
+package union
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fields "k8s.io/apimachinery/pkg/fields"
+	klog "k8s.io/klog/v2"
+)
+
+// attachFailure describes why an attach pod is not serving the union mount.
+type attachFailure struct {
+	// err is the union error the failure relates to, i.e. its gRPC code
+	err error
+	// reason is the pod condition, container state or Event reason the failure was diagnosed from
+	reason  string
+	message string
+}
+
+func (f *attachFailure) Error() string {
+	return fmt.Sprintf("%v: %s: %s", f.err, f.reason, f.message)
+}
+
+func (f *attachFailure) Unwrap() error {
+	return f.err
+}
+
+// Container waiting reasons that need a user to step in, e.g. to fix the image of an attach pod template.
+var failedContainerWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"CrashLoopBackOff":           true,
+}
+
+// diagnoseAttachPod looks into the conditions, container statuses and recent Warning Events of pod
+// for the reason it is not Ready. It returns nil if no reason is found, e.g. the pod is still starting.
+func (a *attacher) diagnoseAttachPod(ctx context.Context, pod *v1.Pod) *attachFailure {
+	if f := diagnosePodScheduling(pod); f != nil {
+		return f
+	}
+	if f := diagnosePodContainers(pod); f != nil {
+		return f
+	}
+	return a.diagnosePodEvents(ctx, pod)
+}
+
+// diagnosePodScheduling checks if pod cannot be scheduled, e.g. because of a lower PersistentVolumeClaim
+// that is not bound or not found, or because the target node lacks the resources the pod requests.
+func diagnosePodScheduling(pod *v1.Pod) *attachFailure {
+	for _, c := range pod.Status.Conditions {
+		if c.Type != v1.PodScheduled || c.Status != v1.ConditionFalse || c.Reason != v1.PodReasonUnschedulable {
+			continue
+		}
+		err := ErrAttachPrecondition
+		if strings.Contains(c.Message, "Insufficient") || strings.Contains(c.Message, "Too many pods") {
+			err = ErrAttachResources
+		}
+		return &attachFailure{err: err, reason: c.Reason, message: c.Message}
+	}
+	return nil
+}
+
+// diagnosePodContainers checks if a container of pod cannot start or keeps exiting, e.g. because
+// its image cannot be pulled or mergerfs fails to serve the FUSE mount.
+func diagnosePodContainers(pod *v1.Pod) *attachFailure {
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && failedContainerWaitingReasons[waiting.Reason] {
+			message := waiting.Message
+			// The reason mergerfs exited is on the last termination state
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Message != "" {
+				message = fmt.Sprintf("%s: %s", message, terminated.Message)
+			}
+			return &attachFailure{
+				err:     ErrAttachUnavailable,
+				reason:  waiting.Reason,
+				message: fmt.Sprintf("container %q: %s", status.Name, message),
+			}
+		}
+		if terminated := status.State.Terminated; terminated != nil {
+			return &attachFailure{
+				err:     ErrAttachUnavailable,
+				reason:  terminated.Reason,
+				message: fmt.Sprintf("container %q exited with code %d: %s", status.Name, terminated.ExitCode, terminated.Message),
+			}
+		}
+	}
+	return nil
+}
+
+// diagnosePodEvents returns the latest Warning Event of pod, e.g. FailedMount or FailedAttachVolume
+// of a lower volume, or Unhealthy if the union mount is not live.
+func (a *attacher) diagnosePodEvents(ctx context.Context, pod *v1.Pod) *attachFailure {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+		"type":                v1.EventTypeWarning,
+	}.AsSelector().String()
+
+	events, err := a.kubeClient.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		klog.V(4).Infof("Failed to list events of attach pod %q: %v", pod.Namespace+"/"+pod.Name, err)
+		return nil
+	}
+	if len(events.Items) == 0 {
+		return nil
+	}
+
+	sort.Slice(events.Items, func(i, j int) bool {
+		return eventTime(&events.Items[i]).Before(eventTime(&events.Items[j]))
+	})
+	latest := events.Items[len(events.Items)-1]
+
+	return &attachFailure{err: ErrAttachUnavailable, reason: latest.Reason, message: latest.Message}
+}
+
+// eventTime returns the last time event was observed.
+func eventTime(event *v1.Event) time.Time {
+	if event.Series != nil {
+		return event.Series.LastObservedTime.Time
+	}
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	return event.EventTime.Time
+}
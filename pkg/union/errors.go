@@ -11,4 +11,15 @@ var (
 	ErrNodeNotFound            = errors.New("node resource is not found")
 	ErrAttachmentNotFound      = errors.New("attachment resource is not found")
 	ErrVolumeInUse             = errors.New("volume resource is in use")
+	ErrInsufficientCapacity    = errors.New("insufficient capacity for volume resource")
+	ErrInvalidParameter        = errors.New("invalid volume parameter")
+	ErrAttachPrecondition      = errors.New("attachment precondition is not met")
+	ErrAttachResources         = errors.New("insufficient node resources for attachment")
+	ErrAttachUnavailable       = errors.New("attachment is unavailable")
+	ErrOperationPending        = errors.New("operation is pending")
+	ErrOperationConflict       = errors.New("a conflicting operation is pending")
+	ErrRecoveryAmbiguous       = errors.New("volume resource cannot be recovered unambiguously")
+	ErrLowerClaimProtected     = errors.New("lower claim is protected")
+	ErrLowerClaimPending       = errors.New("lower claim is not bound yet")
+	ErrExpandPrecondition      = errors.New("expansion precondition is not met")
 )
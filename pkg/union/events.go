@@ -0,0 +1,108 @@
+package union
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	kubernetes "k8s.io/client-go/kubernetes"
+	scheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	record "k8s.io/client-go/tools/record"
+	klog "k8s.io/klog/v2"
+
+	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
+)
+
+// Reasons of the Events recorded on VolumeSplits and their "upper" PersistentVolumeClaims.
+const (
+	VolumeSplitCreatedEventReason   = "VolumeSplitCreated"
+	VolumeSplitRecoveredEventReason = "VolumeSplitRecovered"
+	VolumeSplitExpandedEventReason  = "VolumeSplitExpanded"
+	LowerClaimCreatedEventReason    = "LowerClaimCreated"
+	LowerClaimAdoptedEventReason    = "LowerClaimAdopted"
+	LowerClaimBoundEventReason      = "LowerClaimBound"
+	LowerClaimDeletedEventReason    = "LowerClaimDeleted"
+	ProvisioningFailedEventReason   = "ProvisioningFailed"
+	ExpansionFailedEventReason      = "ExpansionFailed"
+	CleanupFailedEventReason        = "CleanupFailed"
+	AttachedEventReason             = "Attached"
+	AttachFailedEventReason         = "AttachFailed"
+	DetachedEventReason             = "Detached"
+	DetachFailedEventReason         = "DetachFailed"
+)
+
+const (
+	// VolumeLabelKey labels lower PersistentVolumeClaims with the ID of their volume.
+	VolumeLabelKey = "union.io/volume"
+	// BranchIndexAnnotationKey annotates lower PersistentVolumeClaims with their index among the branches of their volume.
+	BranchIndexAnnotationKey = "union.io/branch-index"
+	// AttachBackendAnnotationKey and AttachPodTemplateAnnotationKey annotate lower PersistentVolumeClaims
+	// with how their volume is attached, for its VolumeSplit to be recovered from them.
+	AttachBackendAnnotationKey     = "union.io/attach-backend"
+	AttachPodTemplateAnnotationKey = "union.io/attach-pod-template"
+	// UnprotectedAnnotationKey set to "true" lets lower PersistentVolumeClaims be deleted or changed
+	// past the admission webhook while their volume exists.
+	UnprotectedAnnotationKey = "union.io/unprotected"
+)
+
+// volumeRecorder records Events on the VolumeSplit of a volume and on its "upper" PersistentVolumeClaim, if known,
+// so users can follow what happens to their volume without going through the logs of the driver.
+type volumeRecorder struct {
+	recorder    record.EventRecorder
+	claimLister corelisters.PersistentVolumeClaimLister
+}
+
+func newVolumeRecorder(kubeClient kubernetes.Interface, claimLister corelisters.PersistentVolumeClaimLister) *volumeRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
+	return &volumeRecorder{
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "union-csi-driver"}),
+		claimLister: claimLister,
+	}
+}
+
+// Eventf records an Event on the VolumeSplit and the "upper" PersistentVolumeClaim of volume.
+func (r *volumeRecorder) Eventf(volume *Volume, eventtype, reason, messageFmt string, args ...interface{}) {
+	if volume.SplitRef != nil {
+		r.recorder.Eventf(volume.SplitRef, eventtype, reason, messageFmt, args...)
+	}
+	if volume.ClaimRef == nil {
+		return
+	}
+	// Get the claim for its UID, Events of other claims with the same name must not show up.
+	claim, err := r.claimLister.PersistentVolumeClaims(volume.ClaimRef.Namespace).Get(volume.ClaimRef.Name)
+	if err != nil {
+		klog.V(4).Infof("Failed to get claim %q of volume %q to record event: %v", volume.ClaimRef.Namespace+"/"+volume.ClaimRef.Name, volume.VolumeId, err)
+		return
+	}
+	r.recorder.Eventf(claim, eventtype, reason, messageFmt, args...)
+}
+
+// SplitEventf records an Event on split and its "upper" PersistentVolumeClaim.
+func (r *volumeRecorder) SplitEventf(split *v1alpha1.VolumeSplit, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(NewVolumeFromVolumeSplit(split), eventtype, reason, messageFmt, args...)
+}
+
+// makeSplitRef returns a reference to split for Events to be recorded on.
+func makeSplitRef(split *v1alpha1.VolumeSplit) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       "VolumeSplit",
+		Name:       split.Name,
+		UID:        split.UID,
+	}
+}
+
+// describeSplits returns the lower claims of split with their sizes, e.g. "ns/pvc-lower0 (5Gi), ns/pvc-lower1 (5Gi)".
+func describeSplits(split *v1alpha1.VolumeSplit) string {
+	var claims []string
+	for _, claimSplit := range split.Spec.Splits {
+		storage := claimSplit.Resources.Requests[v1.ResourceStorage]
+		claims = append(claims, fmt.Sprintf("%s/%s (%s)", split.Spec.Namespace, claimSplit.ClaimName, storage.String()))
+	}
+	return strings.Join(claims, ", ")
+}
@@ -0,0 +1,267 @@
+package union
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	wait "k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	kubernetes "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	klog "k8s.io/klog/v2"
+
+	pod "github.com/on2e/union-csi-driver/pkg/union/pod"
+)
+
+// nodeAttacher implements the Attacher interface without attach pods.
+// Attach/Detach create/delete one holder pod per lower claim of the volume at the target node. Holder pods only
+// use their lower claim, so kubelet and the attach/detach controller attach, stage and publish the lower volumes
+// there as they would for any other pod, through the registered socket of the lower driver and with its secrets.
+// The node plugin then merges the lower volumes from where kubelet published them for the holder pods,
+// directly on the host (see GetBranches). One pod per lower claim lets expansion add lower claims
+// without touching the lower volumes already merged.
+// Lower volumes must be CSI volumes.
+type nodeAttacher struct {
+	kubeClient  kubernetes.Interface
+	claimLister corelisters.PersistentVolumeClaimLister
+	podLister   corelisters.PodLister
+	podFactory  *pod.Factory
+	// podWaiters wakes up waiting for holder pods on their events
+	podWaiters *podWaiters
+	// backoff of waiting for holder pods
+	backoff wait.Backoff
+}
+
+var _ Attacher = &nodeAttacher{}
+
+func NewNodeAttacher(kubeClient kubernetes.Interface, claimLister corelisters.PersistentVolumeClaimLister, podInformer coreinformers.PodInformer, podFactory *pod.Factory, backoff wait.Backoff) *nodeAttacher {
+	return &nodeAttacher{
+		kubeClient:  kubeClient,
+		claimLister: claimLister,
+		podLister:   podInformer.Lister(),
+		podFactory:  podFactory,
+		podWaiters:  newPodWaiters(podInformer),
+		backoff:     backoff,
+	}
+}
+
+// Attach creates the missing holder pods of the lower claims of volume at node nodeId and waits for them to run.
+// It is also called for volumes already attached, after their expansion added lower claims.
+func (a *nodeAttacher) Attach(ctx context.Context, volume *Volume, nodeId string) (*VolumeAttachment, error) {
+	for _, claimName := range volume.ClaimNames {
+		if err := a.createHolderPod(ctx, volume, claimName, nodeId); err != nil {
+			return nil, err
+		}
+	}
+
+	klog.Infof("Start waiting for attachment of volume %q at node %q", volume.VolumeId, nodeId)
+	for _, claimName := range volume.ClaimNames {
+		if err := a.waitForHolderPod(ctx, volume, claimName, nodeId); err != nil {
+			return nil, err
+		}
+	}
+
+	// The union mount is served at the staging path of the node plugin, there is no host path.
+	return &VolumeAttachment{VolumeId: volume.VolumeId, NodeId: nodeId}, nil
+}
+
+// createHolderPod creates the holder pod of lower claim claimName at node nodeId, if it does not exist.
+func (a *nodeAttacher) createHolderPod(ctx context.Context, volume *Volume, claimName, nodeId string) error {
+	podName := makeHolderPodName(claimName, nodeId)
+	podKey := volume.Namespace + "/" + podName
+
+	holder, err := a.podLister.Pods(volume.Namespace).Get(podName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting pod %q: %v", podKey, err)
+	}
+	if holder != nil {
+		// A failed holder pod, e.g. evicted, no longer holds the lower volume. Replace it.
+		if !isPodTerminating(holder) || holder.DeletionTimestamp != nil {
+			klog.Infof("Holder pod %q of lower claim %q for volume %q already exists", podKey, claimName, volume.VolumeId)
+			return nil
+		}
+		klog.Infof("Holder pod %q for volume %q has phase %s (%s), deleting it to create a new one", podKey, volume.VolumeId, holder.Status.Phase, holder.Status.Reason)
+		if _, err := a.deleteHolderPod(ctx, volume, podName); err != nil {
+			return err
+		}
+	}
+
+	holder, err = a.podFactory.CreateHolder(podName, volume.Namespace, claimName, nodeId)
+	if err != nil {
+		return fmt.Errorf("error creating holder pod %q for volume %q: %v", podKey, volume.VolumeId, err)
+	}
+	_, err = a.kubeClient.CoreV1().Pods(volume.Namespace).Create(ctx, holder, metav1.CreateOptions{})
+	if err == nil {
+		klog.Infof("Created holder pod %q of lower claim %q for volume %q at node %q", podKey, claimName, volume.VolumeId, nodeId)
+	} else if apierrors.IsAlreadyExists(err) {
+		klog.Infof("Holder pod %q of lower claim %q for volume %q already exists", podKey, claimName, volume.VolumeId)
+	} else {
+		return fmt.Errorf("error creating pod %q: %v", podKey, err)
+	}
+
+	return nil
+}
+
+// waitForHolderPod waits for the holder pod of lower claim claimName to run at node nodeId.
+// Kubelet starts its container only once the lower volume is mounted.
+func (a *nodeAttacher) waitForHolderPod(ctx context.Context, volume *Volume, claimName, nodeId string) error {
+	podName := makeHolderPodName(claimName, nodeId)
+	podKey := volume.Namespace + "/" + podName
+
+	waitForAttachFunc := func(ctx context.Context) (bool, error) {
+		holder, err := a.podLister.Pods(volume.Namespace).Get(podName)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return false, fmt.Errorf("error getting pod %q: %v", podKey, err)
+			}
+			// Pod may have not made it in local cache yet, return nil error to continue waiting
+			return false, nil
+		}
+		if isPodTerminating(holder) || holder.DeletionTimestamp != nil {
+			return false, fmt.Errorf("holder pod %q for volume %q is terminating", podKey, volume.VolumeId)
+		}
+		if !isPodRunning(holder) {
+			klog.Infof("Holder pod %q for volume %q is not running yet, continue waiting for attachment ...", podKey, volume.VolumeId)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	return a.podWaiters.wait(ctx, volume.Namespace, podName, a.backoff, waitForAttachFunc)
+}
+
+// Detach deletes the holder pods of volume at node nodeId and waits for them to be removed,
+// i.e. for kubelet to unmount the lower volumes. It returns ErrAttachmentNotFound if there were none.
+func (a *nodeAttacher) Detach(ctx context.Context, volume *Volume, nodeId string) error {
+	var found bool
+	klog.Infof("Start waiting for detachment of volume %q at node %q", volume.VolumeId, nodeId)
+	for _, claimName := range volume.ClaimNames {
+		deleted, err := a.deleteHolderPod(ctx, volume, makeHolderPodName(claimName, nodeId))
+		if err != nil {
+			return err
+		}
+		found = found || deleted
+	}
+
+	if !found {
+		return fmt.Errorf("%w: volume %q has no holder pods at node %q", ErrAttachmentNotFound, volume.VolumeId, nodeId)
+	}
+	return nil
+}
+
+// deleteHolderPod deletes holder pod podName of volume and waits for it to be removed.
+// It returns false if the holder pod did not exist.
+func (a *nodeAttacher) deleteHolderPod(ctx context.Context, volume *Volume, podName string) (bool, error) {
+	podKey := volume.Namespace + "/" + podName
+
+	err := a.kubeClient.CoreV1().Pods(volume.Namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	if err == nil {
+		klog.Infof("Deleted holder pod %q for volume %q", podKey, volume.VolumeId)
+	} else if apierrors.IsNotFound(err) {
+		klog.Infof("Holder pod %q for volume %q does not exist", podKey, volume.VolumeId)
+		return false, nil
+	} else {
+		return false, fmt.Errorf("error deleting pod %q: %v", podKey, err)
+	}
+
+	waitForDetachFunc := func(ctx context.Context) (bool, error) {
+		_, err := a.podLister.Pods(volume.Namespace).Get(podName)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return false, fmt.Errorf("error getting pod %q: %v", podKey, err)
+			}
+			// Pod is removed from API, deletion is complete, stop waiting
+			return true, nil
+		}
+		klog.Infof("Holder pod %q for volume %q is not removed from API server yet, continue waiting for detachment ...", podKey, volume.VolumeId)
+		return false, nil
+	}
+
+	return true, a.podWaiters.wait(ctx, volume.Namespace, podName, a.backoff, waitForDetachFunc)
+}
+
+// IsAttached checks if any holder pod of volume exists at node nodeId.
+func (a *nodeAttacher) IsAttached(ctx context.Context, volume *Volume, nodeId string) (bool, error) {
+	for _, claimName := range volume.ClaimNames {
+		podName := makeHolderPodName(claimName, nodeId)
+		_, err := a.podLister.Pods(volume.Namespace).Get(podName)
+		if err == nil {
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("error getting pod \"%s/%s\": %v", volume.Namespace, podName, err)
+		}
+
+	}
+	return false, nil
+}
+
+// GetBranches returns the lower volumes of volume for the node plugin to merge at node nodeId,
+// identified by the holder pods kubelet published them for.
+func (a *nodeAttacher) GetBranches(ctx context.Context, volume *Volume, nodeId string) ([]*Branch, error) {
+	var branches []*Branch
+
+	for _, claimName := range volume.ClaimNames {
+		podName := makeHolderPodName(claimName, nodeId)
+		podKey := volume.Namespace + "/" + podName
+
+		holder, err := a.podLister.Pods(volume.Namespace).Get(podName)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("%w: holder pod %q for volume %q does not exist", ErrAttachmentNotFound, podKey, volume.VolumeId)
+			}
+			return nil, fmt.Errorf("error getting pod %q: %v", podKey, err)
+		}
+		if !isPodRunning(holder) || holder.DeletionTimestamp != nil || holder.Spec.NodeName != nodeId {
+			return nil, fmt.Errorf("%w: holder pod %q for volume %q is not running at node %q", ErrAttachUnavailable, podKey, volume.VolumeId, nodeId)
+		}
+
+		pv, err := a.getLowerVolume(ctx, volume.Namespace, claimName)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, &Branch{
+			ClaimName:  claimName,
+			VolumeName: pv.Name,
+			PodUID:     string(holder.UID),
+		})
+	}
+
+	return branches, nil
+}
+
+// getLowerVolume returns the CSI PersistentVolume bound to the lower claim namespace/claimName.
+func (a *nodeAttacher) getLowerVolume(ctx context.Context, namespace, claimName string) (*v1.PersistentVolume, error) {
+	claimKey := namespace + "/" + claimName
+
+	claim, err := a.claimLister.PersistentVolumeClaims(namespace).Get(claimName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting lower claim %q: %v", claimKey, err)
+	}
+
+	if claim.Status.Phase != v1.ClaimBound || claim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("%w: lower claim %q", ErrLowerClaimPending, claimKey)
+	}
+
+	pv, err := a.kubeClient.CoreV1().PersistentVolumes().Get(ctx, claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting lower volume %q of claim %q: %v", claim.Spec.VolumeName, claimKey, err)
+	}
+
+	if pv.Spec.CSI == nil {
+		return nil, fmt.Errorf("lower volume %q of claim %q is not a CSI volume, node attach backend supports only CSI lower volumes", pv.Name, claimKey)
+	}
+
+	return pv, nil
+}
+
+// makeHolderPodName returns holder-pod-<sha256(claimName/nodeId)>
+func makeHolderPodName(claimName, nodeId string) string {
+	result := sha256.Sum256([]byte(claimName + "/" + nodeId))
+	return fmt.Sprintf("holder-pod-%x", result)
+}
@@ -0,0 +1,118 @@
+package union
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+)
+
+/*
+	Attaching and detaching a volume can take minutes, e.g. for an attach pod to pull its image, longer than
+	external-attacher waits for ControllerPublishVolume/ControllerUnpublishVolume to return. Instead of blocking
+	the call, the operation runs in the background and the call waits for it for a while only:
+
+	* a call that finds the same operation in progress waits for it too instead of starting another one
+	* a call that finds a different operation in progress for the same volume and node fails with ErrOperationConflict
+	* a call that gives up waiting fails with ErrOperationPending, the operation carries on
+	* the outcome of a completed operation is kept for the next call of the same operation to return
+*/
+
+type operationKind string
+
+const (
+	attachOperation operationKind = "attach"
+	detachOperation operationKind = "detach"
+)
+
+// operation is an attach or detach of a volume at a node.
+type operation struct {
+	kind operationKind
+	// done is closed once the operation completes, attachment and err hold its outcome then
+	done       chan struct{}
+	attachment *VolumeAttachment
+	err        error
+}
+
+func (op *operation) isDone() bool {
+	select {
+	case <-op.done:
+		return true
+	default:
+		return false
+	}
+}
+
+type operationFunc func(ctx context.Context) (*VolumeAttachment, error)
+
+// operationTracker runs operations in the background, one at a time per volume and node.
+type operationTracker struct {
+	mu sync.Mutex
+	// operations are keyed by volume ID and node ID, completed operations are kept until their outcome is returned
+	operations map[string]*operation
+
+	// timeout bounds every operation
+	timeout time.Duration
+	// wait is how long a call waits for an operation to complete
+	wait time.Duration
+}
+
+func newOperationTracker(timeout, wait time.Duration) *operationTracker {
+	return &operationTracker{
+		operations: make(map[string]*operation),
+		timeout:    timeout,
+		wait:       wait,
+	}
+}
+
+// run runs fn as the operation kind of volumeId at nodeId, or joins the one in progress,
+// and returns its outcome if it completes in time.
+func (t *operationTracker) run(ctx context.Context, kind operationKind, volumeId, nodeId string, fn operationFunc) (*VolumeAttachment, error) {
+	key := volumeId + "/" + nodeId
+
+	t.mu.Lock()
+	op, ok := t.operations[key]
+	switch {
+	case ok && op.kind != kind && !op.isDone():
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s of volume %q at node %q is in progress", ErrOperationConflict, op.kind, volumeId, nodeId)
+	case ok && op.kind == kind:
+		klog.V(4).Infof("Joining %s of volume %q at node %q", kind, volumeId, nodeId)
+	default:
+		// No operation or the outcome of a different one nobody asked for, which is stale now
+		op = &operation{kind: kind, done: make(chan struct{})}
+		t.operations[key] = op
+		go t.do(op, fn)
+	}
+	t.mu.Unlock()
+
+	timer := time.NewTimer(t.wait)
+	defer timer.Stop()
+
+	select {
+	case <-op.done:
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: %s of volume %q at node %q is in progress", ErrOperationPending, kind, volumeId, nodeId)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %s of volume %q at node %q is in progress: %v", ErrOperationPending, kind, volumeId, nodeId, ctx.Err())
+	}
+
+	// The outcome is returned once, the next call starts over.
+	t.mu.Lock()
+	if t.operations[key] == op {
+		delete(t.operations, key)
+	}
+	t.mu.Unlock()
+
+	return op.attachment, op.err
+}
+
+func (t *operationTracker) do(op *operation, fn operationFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	op.attachment, op.err = fn(ctx)
+	close(op.done)
+}
@@ -5,18 +5,44 @@ import (
 )
 
 // Factory produces attach pods
-type Factory struct{}
+type Factory struct {
+	templates *Templates
+}
 
-func NewFactory() *Factory {
-	return &Factory{}
+func NewFactory(templates *Templates) *Factory {
+	if templates == nil {
+		templates = NewTemplates()
+	}
+	return &Factory{templates: templates}
 }
 
-// Create creates a new attach pod
+// Create creates a new attach pod from the attach pod template named templateName
 func (f *Factory) Create(
 	podName string,
 	podNamespace string,
 	claimNames []string,
 	hostPath string,
-	volumeId string) *v1.Pod {
-	return NewBuilder(podName, podNamespace, claimNames, hostPath, volumeId).Build()
+	volumeId string,
+	generation int64,
+	templateName string) (*v1.Pod, error) {
+	template, err := f.templates.Get(templateName)
+	if err != nil {
+		return nil, err
+	}
+	return NewBuilder(podName, podNamespace, claimNames, hostPath, volumeId, generation, template).Build(), nil
+}
+
+// IsPrivileged checks if attach pods of the template named templateName run privileged.
+func (f *Factory) IsPrivileged(templateName string) bool {
+	template, err := f.templates.Get(templateName)
+	if err != nil {
+		return true
+	}
+	return template.IsPrivileged()
+}
+
+// HasTemplate checks if the attach pod template named templateName exists.
+func (f *Factory) HasTemplate(templateName string) bool {
+	_, err := f.templates.Get(templateName)
+	return err == nil
 }
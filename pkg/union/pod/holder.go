@@ -0,0 +1,70 @@
+package pod
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The image of holder pods, it only has to keep the pod running
+	HolderImage = "registry.k8s.io/pause:3.9"
+	// The name of the holder pod container
+	holderContainerName = "holder"
+	// The user the pause image runs as
+	holderUser = 65535
+)
+
+// CreateHolder creates a holder pod of a lower claim for volumes of the node and daemon attach backends.
+// Holder pods only use their lower claim on node nodeId, so that kubelet and the attach/detach controller attach,
+// stage and publish the lower volume there, with the node-stage and node-publish secrets of its PersistentVolume,
+// the way they would for any other pod. The node plugin merges the lower volumes from the pod volume directories
+// kubelet publishes them at. Holder pods take the scheduling fields of the default attach pod template.
+func (f *Factory) CreateHolder(podName, podNamespace, claimName, nodeId string) (*v1.Pod, error) {
+	template, err := f.templates.Get("")
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBuilder(podName, podNamespace, []string{claimName}, "", "", 0, template)
+
+	nonRoot := true
+	user := int64(holderUser)
+	noEscalation := false
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: podNamespace,
+			Labels:    template.Labels,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:            holderContainerName,
+					Image:           HolderImage,
+					ImagePullPolicy: v1.PullIfNotPresent,
+					SecurityContext: &v1.SecurityContext{
+						RunAsNonRoot:             &nonRoot,
+						RunAsUser:                &user,
+						AllowPrivilegeEscalation: &noEscalation,
+						Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+						SeccompProfile:           &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+					},
+				},
+			},
+			// Scheduled rather than bound to the node, so that lower claims with delayed binding get bound at the node
+			NodeSelector:       map[string]string{"kubernetes.io/hostname": nodeId},
+			Tolerations:        template.Tolerations,
+			PriorityClassName:  template.PriorityClassName,
+			ServiceAccountName: template.ServiceAccountName,
+		},
+	}
+
+	for _, secret := range template.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: secret})
+	}
+
+	container := &pod.Spec.Containers[0]
+	b.addPVCVolumesAndVolumeMounts(&pod.Spec.Volumes, &container.VolumeMounts)
+
+	return pod, nil
+}
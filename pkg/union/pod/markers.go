@@ -0,0 +1,83 @@
+package pod
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The image command that prints the branch markers as a JSON array
+	markersCommandString = "gogomergerfs markers --branches=%s"
+)
+
+// CreateMarkerReader creates a pod that prints the branch markers of the lower claims claimNames of volumeId
+// to its log and exits, see the markers command of gogomergerfs. The lower claims are mounted read-only
+// and unmarked branches are left unmarked. If nodeId is set the pod is scheduled at that node,
+// e.g. where the lower volumes are attached already and cannot be attached elsewhere.
+// Marker reader pods take the image and scheduling fields of the default attach pod template.
+func (f *Factory) CreateMarkerReader(podName, podNamespace string, claimNames []string, volumeId, nodeId string) (*v1.Pod, error) {
+	template, err := f.templates.Get("")
+	if err != nil {
+		return nil, err
+	}
+
+	b := NewBuilder(podName, podNamespace, claimNames, "", volumeId, 0, template)
+
+	noEscalation := false
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: podNamespace,
+			Labels:    template.Labels,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:            commandName,
+					Image:           template.Image,
+					ImagePullPolicy: template.ImagePullPolicy,
+					Resources:       template.Resources,
+					// Root to read the markers whatever the owner and mode of the branch roots are, with no other privileges
+					SecurityContext: &v1.SecurityContext{
+						AllowPrivilegeEscalation: &noEscalation,
+						Capabilities: &v1.Capabilities{
+							Drop: []v1.Capability{"ALL"},
+							Add:  []v1.Capability{"DAC_READ_SEARCH"},
+						},
+						SeccompProfile: &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault},
+					},
+				},
+			},
+			RestartPolicy:      v1.RestartPolicyNever,
+			Tolerations:        template.Tolerations,
+			PriorityClassName:  template.PriorityClassName,
+			ServiceAccountName: template.ServiceAccountName,
+		},
+	}
+
+	if nodeId != "" {
+		pod.Spec.NodeSelector = map[string]string{"kubernetes.io/hostname": nodeId}
+	}
+
+	for _, secret := range template.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: secret})
+	}
+
+	container := &pod.Spec.Containers[0]
+	b.addPVCVolumesAndVolumeMounts(&pod.Spec.Volumes, &container.VolumeMounts)
+	for i := range container.VolumeMounts {
+		container.VolumeMounts[i].ReadOnly = true
+	}
+
+	var branches []string
+	for i, claimName := range claimNames {
+		branches = append(branches, b.makeBranchPath(i, claimName))
+	}
+	container.Command = []string{"/bin/sh"}
+	container.Args = []string{"-c", fmt.Sprintf(markersCommandString, strings.Join(branches, ","))}
+
+	return pod, nil
+}
@@ -4,18 +4,30 @@ import (
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mount "github.com/on2e/union-csi-driver/pkg/mount"
 )
 
 const (
-	// The mergerfs-wrapped image to use
-	image = "docker.io/on2e/gogomergerfs:demo-mergerfs2.37.1"
+	// The device unprivileged attach pods need to serve FUSE mounts
+	fuseDevice = "/dev/fuse"
+	// The AppArmor profile annotation of the attach pod container
+	appArmorAnnotation = "container.apparmor.security.beta.kubernetes.io/" + commandName
+	// The AppArmor profile of unprivileged attach pods if unset in the template,
+	// loaded on the nodes by the node plugin of the unprivileged deployment
+	defaultAppArmorProfile = "localhost/union-mergerfs"
 	// The image entrypoint
 	commandName = "gogomergerfs"
 	// The image command as a string to be formatted with flag values and fed to a shell
-	commandString = "gogomergerfs mergerfs --branches=%s --target=%s --block"
+	commandString = "gogomergerfs mergerfs --branches=%s --target=%s --options=%s --volume-id=%s --generation=%d --block"
+	// The image command that checks the union mount is live
+	probeCommandString = "gogomergerfs probe --target=%s"
+	// The name of the volume the union mount is served at
+	TargetVolumeName = "target"
 )
 
 // Builder contains information to build attach pods
@@ -25,6 +37,9 @@ type Builder struct {
 	podNamespace string
 	claimNames   []string
 	hostPath     string
+	volumeId     string
+	generation   int64
+	template     *Template
 	// derived
 	containerPath string
 }
@@ -34,61 +49,150 @@ func NewBuilder(
 	podNamespace string,
 	claimNames []string,
 	hostPath string,
-	volumeId string) *Builder {
+	volumeId string,
+	generation int64,
+	template *Template) *Builder {
 	return &Builder{
 		podName:       podName,
 		podNamespace:  podNamespace,
 		claimNames:    claimNames,
 		hostPath:      hostPath,
-		containerPath: filepath.Join("/volume", volumeId),
+		volumeId:      volumeId,
+		generation:    generation,
+		template:      template,
+		containerPath: makeContainerPath(volumeId),
 	}
 }
 
+func makeContainerPath(volumeId string) string {
+	return filepath.Join("/volume", volumeId)
+}
+
 // Build builds the attach pod
 func (b *Builder) Build() *v1.Pod {
 	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      b.podName,
 			Namespace: b.podNamespace,
+			Labels:    b.template.Labels,
 		},
 		Spec: v1.PodSpec{
 			Containers: []v1.Container{
 				{
-					Name:  commandName,
-					Image: image,
-					// PullAlways for now, we are on development and we expect different,
-					// experimental versions of the image with the same tag
-					ImagePullPolicy: v1.PullAlways,
+					Name:            commandName,
+					Image:           b.template.Image,
+					ImagePullPolicy: b.template.ImagePullPolicy,
+					Resources:       b.template.Resources,
 				},
 			},
+			Tolerations:        b.template.Tolerations,
+			PriorityClassName:  b.template.PriorityClassName,
+			ServiceAccountName: b.template.ServiceAccountName,
 		},
 	}
 
-	container := &pod.Spec.Containers[0]
+	for _, secret := range b.template.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: secret})
+	}
 
-	// Run container in privileged mode
-	privileged := true
-	container.SecurityContext = &v1.SecurityContext{Privileged: &privileged}
+	container := &pod.Spec.Containers[0]
 
 	// Add volumes
 	b.addPVCVolumesAndVolumeMounts(&pod.Spec.Volumes, &container.VolumeMounts)
-	b.addHostPathVolumeAndVolumeMount(&pod.Spec.Volumes, &container.VolumeMounts)
 
-	// The paths to merge together
-	// NOTE: let mergerfs handle globbing: https://github.com/trapexit/mergerfs/tree/2.37.1#globbing
-	branches := filepath.Join(b.containerPath, "branches/\\*")
+	if b.template.IsPrivileged() {
+		// Run container in privileged mode
+		privileged := true
+		container.SecurityContext = &v1.SecurityContext{Privileged: &privileged}
+		b.addHostPathVolumeAndVolumeMount(&pod.Spec.Volumes, &container.VolumeMounts)
+	} else {
+		b.setUnprivileged(pod, container)
+	}
+
+	// The paths to merge together, in order so that gogomergerfs can verify the marker of each branch
+	var branches []string
+	for i, claimName := range b.claimNames {
+		branches = append(branches, b.makeBranchPath(i, claimName))
+	}
 	// The directory to mount the union of the branches
 	target := filepath.Join(b.containerPath, "merged")
 
 	container.Command = []string{"/bin/sh"}
 	container.Args = []string{
 		"-c",
-		fmt.Sprintf(commandString, branches, target),
+		// Mark the union mount as the one of the volume for NodePublishVolume to check
+		fmt.Sprintf(commandString, strings.Join(branches, ","), target, mount.MakeFsNameOption(b.volumeId), b.volumeId, b.generation),
+	}
+
+	// The pod is Running as soon as gogomergerfs starts, report Ready only once the union mount is live
+	container.ReadinessProbe = &v1.Probe{
+		ProbeHandler: v1.ProbeHandler{
+			Exec: &v1.ExecAction{
+				Command: []string{"/bin/sh", "-c", fmt.Sprintf(probeCommandString, target)},
+			},
+		},
+		PeriodSeconds:    2,
+		FailureThreshold: 1,
 	}
 
 	return pod
 }
 
+// setUnprivileged grants container only what mergerfs needs to serve a FUSE mount:
+// SYS_ADMIN for mount(2) and the /dev/fuse device.
+// The union mount stays in the mount namespace of the pod, there is no host path to propagate it to.
+func (b *Builder) setUnprivileged(pod *v1.Pod, container *v1.Container) {
+	privileged := false
+	seccompProfile := b.template.SeccompProfile
+	if seccompProfile == nil {
+		seccompProfile = &v1.SeccompProfile{Type: v1.SeccompProfileTypeRuntimeDefault}
+	}
+	container.SecurityContext = &v1.SecurityContext{
+		Privileged:     &privileged,
+		Capabilities:   &v1.Capabilities{Add: []v1.Capability{"SYS_ADMIN"}},
+		SeccompProfile: seccompProfile,
+	}
+
+	appArmorProfile := b.template.AppArmorProfile
+	if appArmorProfile == "" {
+		appArmorProfile = defaultAppArmorProfile
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[appArmorAnnotation] = appArmorProfile
+
+	charDevice := v1.HostPathCharDev
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name: "fuse",
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{
+				Path: fuseDevice,
+				Type: &charDevice,
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      "fuse",
+		MountPath: fuseDevice,
+	})
+
+	// An empty directory to serve the union mount at
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name:         TargetVolumeName,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      TargetVolumeName,
+		MountPath: filepath.Join(b.containerPath, "/merged"),
+	})
+}
+
+// makeBranchPath returns the path the i-th lower claim claimName is mounted at in the container.
+func (b *Builder) makeBranchPath(i int, claimName string) string {
+	return filepath.Join(b.containerPath, "/branches", "branch"+strconv.Itoa(i)+"-"+claimName)
+}
+
 // addPVCVolumesAndVolumeMounts adds persistentVolumeClaimVolumeSource volumes in pod volumes
 // using Builder.claimNames and matches them to container volumeMounts
 func (b *Builder) addPVCVolumesAndVolumeMounts(volumes *[]v1.Volume, volumeMounts *[]v1.VolumeMount) {
@@ -97,7 +201,7 @@ func (b *Builder) addPVCVolumesAndVolumeMounts(volumes *[]v1.Volume, volumeMount
 		// NOTE: do not put claimName at the start of each branch dir
 		// mergerfs removes the common prefix of the branches in the device name of the union mount,
 		// e.g. branches: branch1:branch2 -> device name: 1:2
-		mountPath := filepath.Join(b.containerPath, "/branches", volumeName+"-"+claimName)
+		mountPath := b.makeBranchPath(i, claimName)
 
 		*volumes = append(*volumes, v1.Volume{
 			Name: volumeName,
@@ -118,7 +222,7 @@ func (b *Builder) addPVCVolumesAndVolumeMounts(volumes *[]v1.Volume, volumeMount
 // addHostPathVolumeAndVolumeMount adds a hostPathVolumeSource volume in pod volumes
 // using Builder.hostPath and matches it to container volumeMounts
 func (b *Builder) addHostPathVolumeAndVolumeMount(volumes *[]v1.Volume, volumeMounts *[]v1.VolumeMount) {
-	volumeName := "target"
+	volumeName := TargetVolumeName
 	mountPath := filepath.Join(b.containerPath, "/merged")
 
 	dirOrCreate := v1.HostPathDirectoryOrCreate
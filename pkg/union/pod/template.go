@@ -0,0 +1,154 @@
+package pod
+
+import (
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	yaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// The default mergerfs-wrapped image to use
+	DefaultImage = "docker.io/on2e/gogomergerfs:demo-mergerfs2.37.1"
+	// PullAlways by default, we are on development and we expect different,
+	// experimental versions of the image with the same tag
+	DefaultImagePullPolicy = v1.PullAlways
+)
+
+// Template holds the configurable fields of attach pods.
+type Template struct {
+	Image              string                  `json:"image,omitempty"`
+	ImagePullPolicy    v1.PullPolicy           `json:"imagePullPolicy,omitempty"`
+	ImagePullSecrets   []string                `json:"imagePullSecrets,omitempty"`
+	Resources          v1.ResourceRequirements `json:"resources,omitempty"`
+	Tolerations        []v1.Toleration         `json:"tolerations,omitempty"`
+	PriorityClassName  string                  `json:"priorityClassName,omitempty"`
+	ServiceAccountName string                  `json:"serviceAccountName,omitempty"`
+	Labels             map[string]string       `json:"labels,omitempty"`
+	// Privileged runs attach pods privileged, the default.
+	// If false, attach pods get only SYS_ADMIN and /dev/fuse, and since Kubernetes allows Bidirectional
+	// mount propagation to privileged containers only, the node plugin picks the union mount up from
+	// the mount namespace of the attach pod instead (requires hostPID on the node plugin).
+	Privileged *bool `json:"privileged,omitempty"`
+	// SeccompProfile of unprivileged attach pods, defaults to RuntimeDefault which allows mount(2) with SYS_ADMIN.
+	SeccompProfile *v1.SeccompProfile `json:"seccompProfile,omitempty"`
+	// AppArmorProfile of unprivileged attach pods, e.g. localhost/<profile>, defaults to localhost/union-mergerfs
+	// since the runtime default profiles deny mount(2).
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+}
+
+// IsPrivileged checks if attach pods of t run privileged.
+func (t *Template) IsPrivileged() bool {
+	return t.Privileged == nil || *t.Privileged
+}
+
+// Templates holds the default attach pod template and any named templates
+// that StorageClasses can select to override it, e.g.:
+//
+//	default:
+//	  image: registry.local/gogomergerfs:v1
+//	  resources:
+//	    limits:
+//	      memory: 256Mi
+//	templates:
+//	  large:
+//	    resources:
+//	      limits:
+//	        memory: 1Gi
+type Templates struct {
+	Default   Template            `json:"default,omitempty"`
+	Templates map[string]Template `json:"templates,omitempty"`
+}
+
+func NewTemplates() *Templates {
+	return &Templates{
+		Default: Template{
+			Image:           DefaultImage,
+			ImagePullPolicy: DefaultImagePullPolicy,
+		},
+	}
+}
+
+// LoadTemplates reads Templates from the YAML or JSON file at path, usually mounted from a ConfigMap.
+// Default fields left unset in the file keep their built-in values.
+func LoadTemplates(path string) (*Templates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attach pod templates: %v", err)
+	}
+
+	t := &Templates{}
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, fmt.Errorf("error decoding attach pod templates %s: %v", path, err)
+	}
+
+	t.Default = overlayTemplate(NewTemplates().Default, t.Default)
+
+	return t, nil
+}
+
+// Get returns the template named name overlaid on the default template.
+// An empty name returns the default template.
+func (t *Templates) Get(name string) (*Template, error) {
+	if name == "" {
+		template := t.Default
+		return &template, nil
+	}
+
+	template, ok := t.Templates[name]
+	if !ok {
+		return nil, fmt.Errorf("attach pod template %q not found", name)
+	}
+
+	template = overlayTemplate(t.Default, template)
+	return &template, nil
+}
+
+// overlayTemplate returns base with the fields set in overlay replacing its own.
+func overlayTemplate(base, overlay Template) Template {
+	if overlay.Image != "" {
+		base.Image = overlay.Image
+	}
+	if overlay.ImagePullPolicy != "" {
+		base.ImagePullPolicy = overlay.ImagePullPolicy
+	}
+	if overlay.ImagePullSecrets != nil {
+		base.ImagePullSecrets = overlay.ImagePullSecrets
+	}
+	if overlay.Resources.Requests != nil {
+		base.Resources.Requests = overlay.Resources.Requests
+	}
+	if overlay.Resources.Limits != nil {
+		base.Resources.Limits = overlay.Resources.Limits
+	}
+	if overlay.Tolerations != nil {
+		base.Tolerations = overlay.Tolerations
+	}
+	if overlay.PriorityClassName != "" {
+		base.PriorityClassName = overlay.PriorityClassName
+	}
+	if overlay.ServiceAccountName != "" {
+		base.ServiceAccountName = overlay.ServiceAccountName
+	}
+	if overlay.Privileged != nil {
+		base.Privileged = overlay.Privileged
+	}
+	if overlay.SeccompProfile != nil {
+		base.SeccompProfile = overlay.SeccompProfile
+	}
+	if overlay.AppArmorProfile != "" {
+		base.AppArmorProfile = overlay.AppArmorProfile
+	}
+	if overlay.Labels != nil {
+		labels := make(map[string]string, len(base.Labels)+len(overlay.Labels))
+		for k, v := range base.Labels {
+			labels[k] = v
+		}
+		for k, v := range overlay.Labels {
+			labels[k] = v
+		}
+		base.Labels = labels
+	}
+	return base
+}
@@ -0,0 +1,120 @@
+package union
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	wait "k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	cache "k8s.io/client-go/tools/cache"
+	klog "k8s.io/klog/v2"
+)
+
+// podWaiters wakes up the waiters of a pod whenever the pod informer sees the pod added, updated or deleted,
+// so that waiting for attach pods takes as long as the pods take instead of being rounded up to the next poll.
+type podWaiters struct {
+	mu sync.Mutex
+	// waiters are keyed by pod key, i.e. <namespace>/<name>
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newPodWaiters(podInformer coreinformers.PodInformer) *podWaiters {
+	w := &podWaiters{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.notify,
+		UpdateFunc: func(_, obj interface{}) { w.notify(obj) },
+		DeleteFunc: w.notify,
+	})
+	return w
+}
+
+// notify wakes up the waiters of obj, a pod or the tombstone of a deleted one.
+func (w *podWaiters) notify(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("Failed to get key of %T: %v", obj, err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.waiters[key] {
+		// A pending wake-up covers this one too
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wait calls condition, which is expected to check the pod through the lister, once and then on every event of
+// the pod until it is done, fails, or ctx is done. Since not all that condition checks comes with an event of the pod,
+// e.g. the Events of a pod that fails to mount its volumes, condition is also polled with backoff. Events of the pod
+// do not advance backoff: wait gives up once all of its steps would have elapsed, however many events came in.
+// It then returns an error that wait.Interrupted reports true for, as it does once ctx is done.
+func (w *podWaiters) wait(ctx context.Context, namespace, name string, backoff wait.Backoff, condition wait.ConditionWithContextFunc) error {
+	key := namespace + "/" + name
+	ch := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	if w.waiters[key] == nil {
+		w.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	w.waiters[key][ch] = struct{}{}
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.waiters[key], ch)
+		if len(w.waiters[key]) == 0 {
+			delete(w.waiters, key)
+		}
+	}()
+
+	if done, err := condition(ctx); err != nil || done {
+		return err
+	}
+	if backoff.Steps <= 0 {
+		return wait.ErrWaitTimeout
+	}
+
+	deadline := time.NewTimer(getBackoffTimeout(backoff))
+	defer deadline.Stop()
+	poll := time.NewTimer(backoff.Step())
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ch:
+		case <-poll.C:
+			if backoff.Steps > 0 {
+				poll.Reset(backoff.Step())
+			}
+		case <-deadline.C:
+			if done, err := condition(ctx); err != nil || done {
+				return err
+			}
+			return wait.ErrWaitTimeout
+		case <-ctx.Done():
+			return wait.ErrorInterrupted(ctx.Err())
+		}
+
+		if done, err := condition(ctx); err != nil || done {
+			return err
+		}
+	}
+}
+
+// getBackoffTimeout returns how long backoff takes to run out of steps.
+func getBackoffTimeout(backoff wait.Backoff) time.Duration {
+	var timeout time.Duration
+	for backoff.Steps > 0 {
+		timeout += backoff.Step()
+	}
+	return timeout
+}
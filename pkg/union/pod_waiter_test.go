@@ -0,0 +1,77 @@
+package union
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	wait "k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestPodWaitersEventsDoNotShortenBackoff(t *testing.T) {
+	w := &podWaiters{waiters: make(map[string]map[chan struct{}]struct{})}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "union", Name: "attach-pod"}}
+	backoff := wait.Backoff{Duration: 20 * time.Millisecond, Factor: 1, Steps: 3}
+
+	// Wake up the waiter far more often than backoff polls.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.notify(pod)
+			}
+		}
+	}()
+
+	var calls atomic.Int32
+	condition := func(ctx context.Context) (bool, error) {
+		calls.Add(1)
+		return false, nil
+	}
+
+	start := time.Now()
+	err := w.wait(context.Background(), pod.Namespace, pod.Name, backoff, condition)
+	elapsed := time.Since(start)
+
+	if !wait.Interrupted(err) {
+		t.Fatalf("wait() error = %v, want a timeout", err)
+	}
+	if timeout := getBackoffTimeout(backoff); elapsed < timeout {
+		t.Errorf("wait() gave up after %v, before the %v of backoff", elapsed, timeout)
+	}
+	if calls.Load() <= int32(backoff.Steps)+1 {
+		t.Errorf("condition called %d times, want it called on events too", calls.Load())
+	}
+}
+
+func TestPodWaitersDoneOnEvent(t *testing.T) {
+	w := &podWaiters{waiters: make(map[string]map[chan struct{}]struct{})}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "union", Name: "attach-pod"}}
+	backoff := wait.Backoff{Duration: time.Minute, Factor: 1, Steps: 1}
+
+	var ready atomic.Bool
+	condition := func(ctx context.Context) (bool, error) {
+		return ready.Load(), nil
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ready.Store(true)
+		w.notify(pod)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := w.wait(ctx, pod.Namespace, pod.Name, backoff, condition); err != nil {
+		t.Fatalf("wait() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,475 @@
+package union
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	selection "k8s.io/apimachinery/pkg/selection"
+	klog "k8s.io/klog/v2"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
+)
+
+// attachBackendVolumeAttribute is the volume attribute the controller sets the attach backend of a volume in,
+// see AttachBackendVolumeContextKey of the driver.
+const attachBackendVolumeAttribute = "attachBackend"
+
+// makeLowerClaimAnnotations returns the annotations of the lower claim at index of split,
+// which together with VolumeLabelKey are enough for RecoverSplit to rebuild split.
+func makeLowerClaimAnnotations(split *v1alpha1.VolumeSplit, index int) map[string]string {
+	annotations := map[string]string{
+		BranchIndexAnnotationKey:   strconv.Itoa(index),
+		AttachBackendAnnotationKey: string(getAttachBackend(&split.Spec)),
+	}
+	if split.Spec.AttachPodTemplate != "" {
+		annotations[AttachPodTemplateAnnotationKey] = split.Spec.AttachPodTemplate
+	}
+	return annotations
+}
+
+// recoverSplits recovers the VolumeSplits of the volumes whose lower claims exist without one.
+func (u *union) recoverSplits(ctx context.Context) {
+	requirement, err := labels.NewRequirement(VolumeLabelKey, selection.Exists, nil)
+	if err != nil {
+		klog.Errorf("Failed to recover VolumeSplits: %v", err)
+		return
+	}
+	claims, err := u.claimLister.List(labels.NewSelector().Add(*requirement))
+	if err != nil {
+		klog.Errorf("Failed to recover VolumeSplits: failed to list lower claims: %v", err)
+		return
+	}
+	splits, err := u.splitter.ListSplits(ctx)
+	if err != nil {
+		klog.Errorf("Failed to recover VolumeSplits: %v", err)
+		return
+	}
+
+	hasSplit := map[string]bool{}
+	for i := range splits {
+		hasSplit[splits[i].Spec.VolumeName] = true
+	}
+
+	volumeIds := map[string]bool{}
+	for _, claim := range claims {
+		if volumeId := claim.Labels[VolumeLabelKey]; !hasSplit[volumeId] {
+			volumeIds[volumeId] = true
+		}
+	}
+
+	for volumeId := range volumeIds {
+		if _, err := u.RecoverSplit(ctx, volumeId); err != nil {
+			klog.Errorf("Failed to recover VolumeSplit of volume %q: %v", volumeId, err)
+		}
+	}
+}
+
+// RecoverSplit recreates the VolumeSplit of volumeId from the lower claims labeled with it and the PersistentVolume
+// of the volume, e.g. after the VolumeSplit was deleted along with its CRD. It does nothing if the VolumeSplit exists.
+// RecoverSplit returns ErrRecoveryAmbiguous rather than guess when the lower claims do not describe a single volume,
+// e.g. they span namespaces, are being deleted, their branch indices have gaps or duplicates or do not match
+// the branch markers on the lower volumes, which a marker reader pod reads.
+// The attachments of the volume are rebuilt from the VolumeAttachments of the CO and the state of the attach backend.
+func (u *union) RecoverSplit(ctx context.Context, volumeId string) (*Volume, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err == nil {
+		return NewVolumeFromVolumeSplit(split), nil
+	}
+	if !errors.Is(err, ErrVolumeNotFound) {
+		return nil, err
+	}
+
+	pv, err := u.kubeClient.CoreV1().PersistentVolumes().Get(ctx, volumeId, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: no PersistentVolume %q: %w", ErrVolumeNotFound, volumeId, err)
+		}
+		return nil, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != volumeId {
+		return nil, fmt.Errorf("%w: PersistentVolume %q is not a volume of the driver with ID %q", ErrRecoveryAmbiguous, pv.Name, volumeId)
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{VolumeLabelKey: volumeId})
+	claims, err := u.kubeClient.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Items) == 0 {
+		return nil, fmt.Errorf("%w: no lower claims labeled %s", ErrVolumeNotFound, selector)
+	}
+
+	nodes, err := u.getUpperAttachments(ctx, pv.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	markers, err := u.readBranchMarkers(ctx, volumeId, claims.Items, pickMarkerReaderNode(nodes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branch markers: %w", err)
+	}
+
+	splitSpec, err := makeSplitSpecFromClaims(volumeId, pv, claims.Items, markers)
+	if err != nil {
+		return nil, err
+	}
+
+	split, err = u.splitter.RestoreSplit(ctx, volumeId, splitSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Recovered VolumeSplit %q of volume %q from lower claims %s", split.Name, volumeId, describeSplits(split))
+	u.recorder.SplitEventf(split, v1.EventTypeNormal, VolumeSplitRecoveredEventReason, "Recovered VolumeSplit of volume %q from lower claims %s", volumeId, describeSplits(split))
+
+	volume := NewVolumeFromVolumeSplit(split)
+	if err := u.recoverAttachments(ctx, volume, nodes); err != nil {
+		return volume, fmt.Errorf("recovered VolumeSplit %q but failed to recover its attachments: %w", split.Name, err)
+	}
+
+	return volume, nil
+}
+
+// getUpperAttachments returns the nodes the VolumeAttachments of the CO attach the PersistentVolume pvName at,
+// mapped to whether the CO still wants the volume attached there, i.e. the VolumeAttachment is not being deleted.
+func (u *union) getUpperAttachments(ctx context.Context, pvName string) (map[string]bool, error) {
+	attachments, err := u.kubeClient.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing VolumeAttachments: %v", err)
+	}
+
+	nodes := map[string]bool{}
+	for i := range attachments.Items {
+		attachment := &attachments.Items[i]
+		if source := attachment.Spec.Source.PersistentVolumeName; source == nil || *source != pvName {
+			continue
+		}
+		nodes[attachment.Spec.NodeName] = attachment.DeletionTimestamp == nil
+	}
+	return nodes, nil
+}
+
+// pickMarkerReaderNode returns the node to read the branch markers at, any node the volume is attached at
+// since its lower volumes may not be attachable anywhere else, or none to let the scheduler pick.
+func pickMarkerReaderNode(nodes map[string]bool) string {
+	var nodeIds []string
+	for nodeId := range nodes {
+		nodeIds = append(nodeIds, nodeId)
+	}
+	if len(nodeIds) == 0 {
+		return ""
+	}
+	sort.Strings(nodeIds)
+	return nodeIds[0]
+}
+
+// readBranchMarkers reads the branch markers of the bound lower claims of volumeId with a marker reader pod
+// at node nodeId and returns them by claim name. Unmarked lower volumes and unbound lower claims,
+// which hold no data yet, have no marker.
+func (u *union) readBranchMarkers(ctx context.Context, volumeId string, claims []v1.PersistentVolumeClaim, nodeId string) (map[string]*merger.BranchMarker, error) {
+	// Lower claims in other namespaces or being deleted are left to makeSplitSpecFromClaims to refuse.
+	namespace := claims[0].Namespace
+	var claimNames []string
+	for i := range claims {
+		claim := &claims[i]
+		if claim.Namespace == namespace && claim.DeletionTimestamp == nil && claim.Status.Phase == v1.ClaimBound {
+			claimNames = append(claimNames, claim.Name)
+		}
+	}
+	if len(claimNames) == 0 {
+		return nil, nil
+	}
+
+	podName := makeMarkerReaderPodName(volumeId)
+	podKey := namespace + "/" + podName
+
+	// A marker reader pod left over from an earlier recovery may mount other lower claims.
+	if err := u.deleteMarkerReaderPod(ctx, namespace, podName); err != nil {
+		return nil, err
+	}
+
+	reader, err := u.podFactory.CreateMarkerReader(podName, namespace, claimNames, volumeId, nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("error building pod %q: %v", podKey, err)
+	}
+	if _, err := u.kubeClient.CoreV1().Pods(namespace).Create(ctx, reader, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("error creating pod %q: %v", podKey, err)
+	}
+	klog.Infof("Created marker reader pod %q for volume %q", podKey, volumeId)
+
+	defer func() {
+		err := u.kubeClient.CoreV1().Pods(namespace).Delete(context.Background(), podName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("Failed to delete marker reader pod %q: %v", podKey, err)
+		}
+	}()
+
+	waitForMarkersFunc := func(ctx context.Context) (bool, error) {
+		pod, err := u.podLister.Pods(namespace).Get(podName)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded:
+			return true, nil
+		case v1.PodFailed:
+			return false, fmt.Errorf("pod %q failed, see its logs", podKey)
+		}
+		return false, nil
+	}
+	if err := u.podWaiters.wait(ctx, namespace, podName, u.backoff, waitForMarkersFunc); err != nil {
+		return nil, fmt.Errorf("error waiting for pod %q: %w", podKey, err)
+	}
+
+	data, err := u.kubeClient.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting logs of pod %q: %v", podKey, err)
+	}
+	var read []*merger.BranchMarker
+	if err := json.Unmarshal(data, &read); err != nil || len(read) != len(claimNames) {
+		return nil, fmt.Errorf("unexpected logs of pod %q: %q", podKey, data)
+	}
+
+	markers := map[string]*merger.BranchMarker{}
+	for i, marker := range read {
+		if marker != nil {
+			markers[claimNames[i]] = marker
+		}
+	}
+	return markers, nil
+}
+
+// deleteMarkerReaderPod deletes the marker reader pod namespace/podName and waits for it to be gone.
+func (u *union) deleteMarkerReaderPod(ctx context.Context, namespace, podName string) error {
+	podKey := namespace + "/" + podName
+
+	err := u.kubeClient.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error deleting pod %q: %v", podKey, err)
+	}
+
+	waitForDeleteFunc := func(ctx context.Context) (bool, error) {
+		_, err := u.podLister.Pods(namespace).Get(podName)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if err := u.podWaiters.wait(ctx, namespace, podName, u.backoff, waitForDeleteFunc); err != nil {
+		return fmt.Errorf("error waiting for pod %q to be deleted: %w", podKey, err)
+	}
+	return nil
+}
+
+// makeMarkerReaderPodName returns markers-pod-<sha256(volumeId)>
+func makeMarkerReaderPodName(volumeId string) string {
+	result := sha256.Sum256([]byte(volumeId))
+	return fmt.Sprintf("markers-pod-%x", result)
+}
+
+// recoverAttachments records the attachments of volume at nodes, as returned by getUpperAttachments,
+// in the state its attach backend reports. Nodes the volume is neither attached at nor wanted at get no record.
+func (u *union) recoverAttachments(ctx context.Context, volume *Volume, nodes map[string]bool) error {
+	attacher, err := u.getAttacher(volume)
+	if err != nil {
+		return err
+	}
+
+	var nodeIds []string
+	for nodeId := range nodes {
+		nodeIds = append(nodeIds, nodeId)
+	}
+	sort.Strings(nodeIds)
+
+	for _, nodeId := range nodeIds {
+		attached, err := attacher.IsAttached(ctx, volume, nodeId)
+		if err != nil {
+			return err
+		}
+
+		var hostPath, podPath string
+		if attached && volume.AttachBackend == AttachBackendPod {
+			if pod, err := u.podLister.Pods(volume.Namespace).Get(makeAttachPodNameForVolume(volume, nodeId)); err == nil {
+				hostPath, podPath = getAttachPodPaths(pod)
+			}
+		}
+
+		_, err = u.updateAttachment(ctx, volume.VolumeId, nodeId, func(_ *v1alpha1.VolumeSplit, a *v1alpha1.VolumeSplitAttachment) error {
+			if nodes[nodeId] {
+				a.DesiredState = v1alpha1.AttachmentStateAttached
+			}
+			if attached {
+				a.ActualState = v1alpha1.AttachmentStateAttached
+				a.HostPath = hostPath
+				a.PodPath = podPath
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record attachment at node %q: %w", nodeId, err)
+		}
+		klog.Infof("Recovered attachment of volume %q at node %q: wanted %t, attached %t", volume.VolumeId, nodeId, nodes[nodeId], attached)
+	}
+	return nil
+}
+
+// makeSplitSpecFromClaims rebuilds the VolumeSplit spec of volumeId from its lower claims and PersistentVolume.
+// The branch index of each lower claim is checked against the branch marker of its lower volume in markers, if any,
+// and taken from the marker if neither the name nor the annotations of the claim tell it.
+func makeSplitSpecFromClaims(volumeId string, pv *v1.PersistentVolume, claims []v1.PersistentVolumeClaim, markers map[string]*merger.BranchMarker) (*v1alpha1.VolumeSplitSpec, error) {
+	// Claims created before the lower claims were annotated are ordered by the index in their name.
+	claimNameRegexp := regexp.MustCompile("(^|-)" + regexp.QuoteMeta(volumeId) + `-lower(\d+)$`)
+
+	ordered := make([]*v1.PersistentVolumeClaim, len(claims))
+	for i := range claims {
+		claim := &claims[i]
+		key := claimToClaimKey(claim)
+
+		if claim.DeletionTimestamp != nil {
+			return nil, fmt.Errorf("%w: lower claim %q is being deleted", ErrRecoveryAmbiguous, key)
+		}
+		if claim.Namespace != claims[0].Namespace {
+			return nil, fmt.Errorf("%w: lower claims span namespaces %q and %q", ErrRecoveryAmbiguous, claims[0].Namespace, claim.Namespace)
+		}
+
+		index := -1
+		if match := claimNameRegexp.FindStringSubmatch(claim.Name); match != nil {
+			index, _ = strconv.Atoi(match[2])
+		}
+		if v, ok := claim.Annotations[BranchIndexAnnotationKey]; ok {
+			annotated, err := strconv.Atoi(v)
+			if err != nil || (index >= 0 && annotated != index) {
+				return nil, fmt.Errorf("%w: lower claim %q has branch index %q that does not match its name", ErrRecoveryAmbiguous, key, v)
+			}
+			index = annotated
+		}
+		if marker, ok := markers[claim.Name]; ok {
+			if marker.VolumeId != volumeId {
+				return nil, fmt.Errorf("%w: lower volume of claim %q is marked as a branch of volume %q", ErrRecoveryAmbiguous, key, marker.VolumeId)
+			}
+			if index >= 0 && marker.Index != index {
+				return nil, fmt.Errorf("%w: lower claim %q is branch %d but its lower volume is marked as branch %d", ErrRecoveryAmbiguous, key, index, marker.Index)
+			}
+			index = marker.Index
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("%w: branch index of lower claim %q is unknown", ErrRecoveryAmbiguous, key)
+		}
+		if index >= len(claims) {
+			return nil, fmt.Errorf("%w: branch %d of %d found, lower claims are missing", ErrRecoveryAmbiguous, index, len(claims))
+		}
+		if ordered[index] != nil {
+			return nil, fmt.Errorf("%w: lower claims %q and %q are both branch %d", ErrRecoveryAmbiguous, claimToClaimKey(ordered[index]), key, index)
+		}
+		ordered[index] = claim
+	}
+
+	first := ordered[0]
+
+	// The attach backend is in the volume attributes of the PersistentVolume,
+	// the annotations of the lower claims only confirm it and add the attach pod template.
+	attachBackend := pv.Spec.CSI.VolumeAttributes[attachBackendVolumeAttribute]
+	if v, ok := first.Annotations[AttachBackendAnnotationKey]; ok {
+		if attachBackend != "" && attachBackend != v {
+			return nil, fmt.Errorf("%w: lower claims have attach backend %q but PersistentVolume %q has %q", ErrRecoveryAmbiguous, v, pv.Name, attachBackend)
+		}
+		attachBackend = v
+	}
+
+	capacityTotal := resource.Quantity{}
+	splitSpec := &v1alpha1.VolumeSplitSpec{
+		VolumeName:        volumeId,
+		AccessModes:       first.Spec.AccessModes,
+		CSIAccessModes:    getCSIAccessModeNamesFromVolume(pv),
+		Namespace:         first.Namespace,
+		StorageClassName:  first.Spec.StorageClassName,
+		AttachBackend:     attachBackend,
+		AttachPodTemplate: first.Annotations[AttachPodTemplateAnnotationKey],
+	}
+
+	for _, claim := range ordered {
+		key := claimToClaimKey(claim)
+
+		if !isSameStorageClassName(claim.Spec.StorageClassName, first.Spec.StorageClassName) {
+			return nil, fmt.Errorf("%w: lower claims %q and %q have different storage classes", ErrRecoveryAmbiguous, claimToClaimKey(first), key)
+		}
+		if !isSameAccessModes(claim.Spec.AccessModes, first.Spec.AccessModes) {
+			return nil, fmt.Errorf("%w: lower claims %q and %q have different access modes", ErrRecoveryAmbiguous, claimToClaimKey(first), key)
+		}
+		for _, annotation := range []string{AttachBackendAnnotationKey, AttachPodTemplateAnnotationKey} {
+			if claim.Annotations[annotation] != first.Annotations[annotation] {
+				return nil, fmt.Errorf("%w: lower claims %q and %q have different %s annotations", ErrRecoveryAmbiguous, claimToClaimKey(first), key, annotation)
+			}
+		}
+
+		size, ok := claim.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			return nil, fmt.Errorf("%w: lower claim %q requests no storage", ErrRecoveryAmbiguous, key)
+		}
+		capacityTotal.Add(size)
+
+		splitSpec.Splits = append(splitSpec.Splits, v1alpha1.PersistentVolumeClaimSplit{
+			ClaimName: claim.Name,
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: size}},
+		})
+	}
+
+	splitSpec.CapacityTotal = v1.ResourceList{v1.ResourceStorage: capacityTotal}
+
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		splitSpec.ClaimRef = &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Name:       ref.Name,
+			Namespace:  ref.Namespace,
+		}
+	}
+
+	return splitSpec, nil
+}
+
+func isSameStorageClassName(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func isSameAccessModes(a, b []v1.PersistentVolumeAccessMode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := func(modes []v1.PersistentVolumeAccessMode) []string {
+		s := make([]string, len(modes))
+		for i, mode := range modes {
+			s[i] = string(mode)
+		}
+		sort.Strings(s)
+		return s
+	}
+	sa, sb := sorted(a), sorted(b)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package union
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	merger "github.com/on2e/union-csi-driver/gogomergerfs/pkg/merger"
+)
+
+func makeRecoveryClaim(name string, annotations map[string]string) v1.PersistentVolumeClaim {
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "union", Name: name, Annotations: annotations},
+		Spec: v1.PersistentVolumeClaimSpec{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+}
+
+func TestMakeSplitSpecFromClaimsMarkers(t *testing.T) {
+	volumeId := "pvc-1"
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: volumeId},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: volumeId}},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		claims    []v1.PersistentVolumeClaim
+		markers   map[string]*merger.BranchMarker
+		wantOrder []string
+		wantErr   bool
+	}{
+		{
+			name:      "markers match names",
+			claims:    []v1.PersistentVolumeClaim{makeRecoveryClaim("pvc-1-lower1", nil), makeRecoveryClaim("pvc-1-lower0", nil)},
+			markers:   map[string]*merger.BranchMarker{"pvc-1-lower0": {VolumeId: volumeId, Index: 0}, "pvc-1-lower1": {VolumeId: volumeId, Index: 1}},
+			wantOrder: []string{"pvc-1-lower0", "pvc-1-lower1"},
+		},
+		{
+			name:    "markers swapped",
+			claims:  []v1.PersistentVolumeClaim{makeRecoveryClaim("pvc-1-lower0", nil), makeRecoveryClaim("pvc-1-lower1", nil)},
+			markers: map[string]*merger.BranchMarker{"pvc-1-lower0": {VolumeId: volumeId, Index: 1}, "pvc-1-lower1": {VolumeId: volumeId, Index: 0}},
+			wantErr: true,
+		},
+		{
+			name:    "marker of another volume",
+			claims:  []v1.PersistentVolumeClaim{makeRecoveryClaim("pvc-1-lower0", nil)},
+			markers: map[string]*merger.BranchMarker{"pvc-1-lower0": {VolumeId: "pvc-2", Index: 0}},
+			wantErr: true,
+		},
+		{
+			name:      "index from markers only",
+			claims:    []v1.PersistentVolumeClaim{makeRecoveryClaim("a", nil), makeRecoveryClaim("b", nil)},
+			markers:   map[string]*merger.BranchMarker{"a": {VolumeId: volumeId, Index: 1}, "b": {VolumeId: volumeId, Index: 0}},
+			wantOrder: []string{"b", "a"},
+		},
+		{
+			name:    "index unknown without markers",
+			claims:  []v1.PersistentVolumeClaim{makeRecoveryClaim("a", nil)},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := makeSplitSpecFromClaims(volumeId, pv, tc.claims, tc.markers)
+			if tc.wantErr {
+				if !errors.Is(err, ErrRecoveryAmbiguous) {
+					t.Fatalf("makeSplitSpecFromClaims() error = %v, want %v", err, ErrRecoveryAmbiguous)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("makeSplitSpecFromClaims() unexpected error: %v", err)
+			}
+			var order []string
+			for _, split := range spec.Splits {
+				order = append(order, split.ClaimName)
+			}
+			if len(order) != len(tc.wantOrder) {
+				t.Fatalf("makeSplitSpecFromClaims() order = %v, want %v", order, tc.wantOrder)
+			}
+			for i := range order {
+				if order[i] != tc.wantOrder[i] {
+					t.Fatalf("makeSplitSpecFromClaims() order = %v, want %v", order, tc.wantOrder)
+				}
+			}
+		})
+	}
+}
@@ -3,11 +3,15 @@ package union
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	klog "k8s.io/klog/v2"
 
 	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
@@ -16,15 +20,30 @@ import (
 
 type Splitter interface {
 	CreateSplit(context.Context, string, *v1alpha1.VolumeSplitSpec) (*v1alpha1.VolumeSplit, error)
+	RestoreSplit(context.Context, string, *v1alpha1.VolumeSplitSpec) (*v1alpha1.VolumeSplit, error)
+	ExpandSplit(context.Context, string, *resource.Quantity) (*v1alpha1.VolumeSplit, error)
 	DeleteSplit(context.Context, string) error
 	GetSplit(context.Context, string) (*v1alpha1.VolumeSplit, error)
+	ListSplits(context.Context) ([]v1alpha1.VolumeSplit, error)
+	UpdateSplitStatus(context.Context, *v1alpha1.VolumeSplit) (*v1alpha1.VolumeSplit, error)
 }
 
+// defaultSplitCount is the number of lower claims a volume is split into
+// when capacity allows it.
+const defaultSplitCount = 2
+
 type splitter struct {
 	unionClient unionclientset.Interface
 	// Implement a VolumeSplit informer and use it here.
 
+	// capacityLister is used to fit lower claims to the free space reported by the lower driver.
+	// If nil, the requested capacity is split evenly.
+	capacityLister storagelisters.CSIStorageCapacityLister
+
 	claimNamePrefix string
+
+	// recorder records the creation of VolumeSplits, if set
+	recorder *volumeRecorder
 }
 
 func NewSplitter(unionClient unionclientset.Interface, options ...SplitterOption) *splitter {
@@ -102,7 +121,13 @@ func (s *splitter) doCreateSplit(ctx context.Context, volumeId, splitName string
 
 	capacityQty := split.Spec.CapacityTotal[v1.ResourceStorage]
 
-	for i, c := range s.splitQuantitySuperDuperDummy(&capacityQty) {
+	quantities, topology, err := s.splitQuantity(&capacityQty, split.Spec.StorageClassName)
+	if err != nil {
+		return nil, err
+	}
+	split.Spec.NodeTopology = topology
+
+	for i, c := range quantities {
 		claimName := s.makeClaimName(split.Spec.VolumeName, i)
 		claimSplit := v1alpha1.PersistentVolumeClaimSplit{
 			ClaimName: claimName,
@@ -111,7 +136,10 @@ func (s *splitter) doCreateSplit(ctx context.Context, volumeId, splitName string
 		split.Spec.Splits = append(split.Spec.Splits, claimSplit)
 	}
 
-	_, err = s.unionClient.UnionV1alpha1().VolumeSplits().Create(ctx, split, metav1.CreateOptions{})
+	split, err = s.unionClient.UnionV1alpha1().VolumeSplits().Create(ctx, split, metav1.CreateOptions{})
+	if err == nil && s.recorder != nil {
+		s.recorder.SplitEventf(split, v1.EventTypeNormal, VolumeSplitCreatedEventReason, "Split volume %q into lower claims %s", volumeId, describeSplits(split))
+	}
 	return
 }
 
@@ -139,6 +167,13 @@ func isSplitSpecCompatible(oldSpec, newSpec *v1alpha1.VolumeSplitSpec) bool {
 		return false
 	}
 
+	if getAttachBackend(oldSpec) != getAttachBackend(newSpec) {
+		return false
+	}
+	if oldSpec.AttachPodTemplate != newSpec.AttachPodTemplate {
+		return false
+	}
+
 	newSize := newSpec.CapacityTotal[v1.ResourceStorage]
 	oldSize := oldSpec.CapacityTotal[v1.ResourceStorage]
 	if newSize.Cmp(oldSize) > 0 {
@@ -162,10 +197,288 @@ func isSplitSpecCompatible(oldSpec, newSpec *v1alpha1.VolumeSplitSpec) bool {
 	return isAccessModesCompatible(oldSpec.AccessModes, newSpec.AccessModes)
 }
 
-func (s *splitter) splitQuantitySuperDuperDummy(q *resource.Quantity) []*resource.Quantity {
-	// TODO: make splitting lossless when quantity is odd
-	v, f := q.Value()/2, q.Format
-	return []*resource.Quantity{resource.NewQuantity(v, f), resource.NewQuantity(v, f)}
+// getAttachBackend returns the attach backend of spec, defaulting to AttachBackendPod.
+func getAttachBackend(spec *v1alpha1.VolumeSplitSpec) AttachBackend {
+	if spec.AttachBackend == "" {
+		return AttachBackendPod
+	}
+	return AttachBackend(spec.AttachBackend)
+}
+
+// splitQuantity splits q into the sizes of the lower claims to create.
+// If the lower storage class has CSIStorageCapacity objects published, the sizes are fitted to the free space
+// of the topology segments, see fitSegments, whose combined node topology is returned.
+// Otherwise q is split evenly and the returned topology is nil.
+func (s *splitter) splitQuantity(q *resource.Quantity, storageClassName *string) ([]*resource.Quantity, *metav1.LabelSelector, error) {
+	segments, err := s.getSegmentCapacities(storageClassName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values []int64
+	var topology *metav1.LabelSelector
+	if len(segments) == 0 {
+		values = splitValueEvenly(q.Value(), defaultSplitCount)
+	} else {
+		values, topology = fitSegments(q.Value(), segments)
+		if values == nil {
+			return nil, nil, fmt.Errorf("%w: %s does not fit in the topology segments of storage class %q",
+				ErrInsufficientCapacity, q.String(), *storageClassName)
+		}
+	}
+
+	quantities := make([]*resource.Quantity, 0, len(values))
+	for _, v := range values {
+		if v > 0 {
+			quantities = append(quantities, resource.NewQuantity(v, q.Format))
+		}
+	}
+	return quantities, topology, nil
+}
+
+// segmentCapacity is the capacity of a storage class in one topology segment, as published in a CSIStorageCapacity object.
+type segmentCapacity struct {
+	nodeTopology *metav1.LabelSelector
+	// capacity is the free space of the segment and maximumVolumeSize the size of the largest volume
+	// that can be created in it, in bytes
+	capacity          int64
+	maximumVolumeSize int64
+}
+
+// getSegmentCapacities returns the capacity of each topology segment of the storage class,
+// as published in CSIStorageCapacity objects. Returns nil if capacity tracking is unavailable for the storage class.
+func (s *splitter) getSegmentCapacities(storageClassName *string) ([]segmentCapacity, error) {
+	// The default storage class cannot be resolved here, treat its capacity as unknown.
+	if s.capacityLister == nil || storageClassName == nil {
+		return nil, nil
+	}
+
+	capacities, err := s.capacityLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing CSIStorageCapacity objects: %v", err)
+	}
+
+	var segments []segmentCapacity
+	for _, c := range capacities {
+		if c.StorageClassName != *storageClassName || c.Capacity == nil {
+			continue
+		}
+		segment := segmentCapacity{
+			nodeTopology:      c.NodeTopology,
+			capacity:          c.Capacity.Value(),
+			maximumVolumeSize: c.Capacity.Value(),
+		}
+		if c.MaximumVolumeSize != nil && c.MaximumVolumeSize.Value() < segment.maximumVolumeSize {
+			segment.maximumVolumeSize = c.MaximumVolumeSize.Value()
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// splitValueEvenly splits value in count parts, the first ones absorbing the remainder.
+func splitValueEvenly(value int64, count int) []int64 {
+	values := make([]int64, count)
+	for i := range values {
+		values[i] = value / int64(count)
+		if int64(i) < value%int64(count) {
+			values[i]++
+		}
+	}
+	return values
+}
+
+// fitValue splits value in as few equal parts as fit in a segment with capacity free space,
+// none of them larger than maximumVolumeSize, e.g. 300 in (400, 400) gives [300] and 300 in (400, 120) gives [100, 100, 100].
+// Returns nil if value does not fit in the segment.
+func fitValue(value, capacity, maximumVolumeSize int64) []int64 {
+	if value <= 0 {
+		return []int64{0}
+	}
+	if value > capacity || maximumVolumeSize <= 0 {
+		return nil
+	}
+	count := (value + maximumVolumeSize - 1) / maximumVolumeSize
+	return splitValueEvenly(value, int(count))
+}
+
+// fitSegments splits value into the sizes of lower claims that fit in the free space of segments and returns them
+// along with the node topology the lower claims are all accessible from, since they are all mounted on one node.
+// A single segment that can hold value is preferred, the one with the most free space, so that the volume
+// stays accessible from as many nodes as possible. Otherwise the segments are filled greedily, most free space first,
+// with unequal lower claims, e.g. 300 in (180, 150) gives [180, 120], skipping the segments that share no nodes
+// with the ones already used. Returns nil if value does not fit.
+func fitSegments(value int64, segments []segmentCapacity) ([]int64, *metav1.LabelSelector) {
+	sorted := make([]segmentCapacity, len(segments))
+	copy(sorted, segments)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].capacity > sorted[j].capacity })
+
+	for i := range sorted {
+		if values := fitValue(value, sorted[i].capacity, sorted[i].maximumVolumeSize); values != nil {
+			return values, sorted[i].nodeTopology
+		}
+	}
+
+	var values []int64
+	var topology *metav1.LabelSelector
+	remaining := value
+	for i := range sorted {
+		part := sorted[i].capacity
+		if part > remaining {
+			part = remaining
+		}
+		if part <= 0 {
+			continue
+		}
+		combined, ok := intersectTopology(topology, sorted[i].nodeTopology)
+		if !ok {
+			continue
+		}
+		parts := fitValue(part, sorted[i].capacity, sorted[i].maximumVolumeSize)
+		if parts == nil {
+			continue
+		}
+		values = append(values, parts...)
+		topology = combined
+		remaining -= part
+		if remaining == 0 {
+			return values, topology
+		}
+	}
+	return nil, nil
+}
+
+// intersectTopology returns the node topology that selects the nodes both a and b select, nil selecting any node,
+// and false if a and b require different values of the same label.
+func intersectTopology(a, b *metav1.LabelSelector) (*metav1.LabelSelector, bool) {
+	if a == nil {
+		return b, true
+	}
+	if b == nil {
+		return a, true
+	}
+
+	combined := a.DeepCopy()
+	for key, value := range b.MatchLabels {
+		if v, ok := combined.MatchLabels[key]; ok && v != value {
+			return nil, false
+		}
+		if combined.MatchLabels == nil {
+			combined.MatchLabels = map[string]string{}
+		}
+		combined.MatchLabels[key] = value
+	}
+	for _, requirement := range b.MatchExpressions {
+		found := false
+		for i := range combined.MatchExpressions {
+			if apiequality.Semantic.DeepEqual(combined.MatchExpressions[i], requirement) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			combined.MatchExpressions = append(combined.MatchExpressions, requirement)
+		}
+	}
+	return combined, true
+}
+
+// ExpandSplit grows the VolumeSplit of volumeId to capacity by adding splits for the lower claims of the extra capacity,
+// fitted to the topology segment of the VolumeSplit if any. Lower claims are never resized in place, since the lower
+// storage class may not allow it and the lower volumes of the node and daemon attach backends are not mounted by kubelet
+// to be expanded on the node. ExpandSplit does nothing if the VolumeSplit already has capacity.
+func (s *splitter) ExpandSplit(ctx context.Context, volumeId string, capacity *resource.Quantity) (*v1alpha1.VolumeSplit, error) {
+	split, err := s.GetSplit(ctx, volumeId)
+	if err != nil {
+		return nil, err
+	}
+
+	capacityTotal := split.Spec.CapacityTotal[v1.ResourceStorage]
+	if capacity.Cmp(capacityTotal) <= 0 {
+		return split, nil
+	}
+	extra := capacity.DeepCopy()
+	extra.Sub(capacityTotal)
+
+	quantities, err := s.fitExpansion(&extra, split)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range quantities {
+		split.Spec.Splits = append(split.Spec.Splits, v1alpha1.PersistentVolumeClaimSplit{
+			ClaimName: s.makeClaimName(split.Spec.VolumeName, len(split.Spec.Splits)),
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: *q}},
+		})
+	}
+	split.Spec.CapacityTotal = v1.ResourceList{v1.ResourceStorage: *capacity}
+
+	split, err = s.unionClient.UnionV1alpha1().VolumeSplits().Update(ctx, split, metav1.UpdateOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			err = fmt.Errorf("%w: %w", ErrVolumeNotFound, err)
+		}
+		klog.Infof("Error expanding VolumeSplit %q: %v", s.makeSplitName(volumeId), err)
+		return nil, err
+	}
+	klog.Infof("Expanded VolumeSplit %q for volume %q to %s", split.Name, volumeId, capacity.String())
+	if s.recorder != nil {
+		s.recorder.SplitEventf(split, v1.EventTypeNormal, VolumeSplitExpandedEventReason, "Expanded volume %q to %s, lower claims %s", volumeId, capacity.String(), describeSplits(split))
+	}
+	return split, nil
+}
+
+// fitExpansion splits extra into the sizes of the lower claims to add to split. If the existing lower claims were fitted
+// to topology segments, the new ones have to fit in the free space of the segments accessible from all the nodes
+// of the volume, so that the volume keeps its node topology. Otherwise extra goes to a single lower claim.
+func (s *splitter) fitExpansion(extra *resource.Quantity, split *v1alpha1.VolumeSplit) ([]*resource.Quantity, error) {
+	values := []int64{extra.Value()}
+
+	if split.Spec.NodeTopology != nil {
+		segments, err := s.getSegmentCapacities(split.Spec.StorageClassName)
+		if err != nil {
+			return nil, err
+		}
+		// A segment is accessible from all the nodes of the volume if it adds nothing to the node topology of the volume.
+		var accessible []segmentCapacity
+		for i := range segments {
+			combined, ok := intersectTopology(split.Spec.NodeTopology, segments[i].nodeTopology)
+			if ok && apiequality.Semantic.DeepEqual(combined, split.Spec.NodeTopology) {
+				accessible = append(accessible, segments[i])
+			}
+		}
+		if values, _ = fitSegments(extra.Value(), accessible); values == nil {
+			return nil, fmt.Errorf("%w: %s more does not fit in the topology segments of the lower claims of volume %q",
+				ErrInsufficientCapacity, extra.String(), split.Spec.VolumeName)
+		}
+	}
+
+	quantities := make([]*resource.Quantity, 0, len(values))
+	for _, v := range values {
+		if v > 0 {
+			quantities = append(quantities, resource.NewQuantity(v, extra.Format))
+		}
+	}
+	return quantities, nil
+}
+
+// RestoreSplit creates the VolumeSplit of volumeId with splitSpec as is, splits included,
+// for VolumeSplits recovered from the lower claims they had created.
+func (s *splitter) RestoreSplit(ctx context.Context, volumeId string, splitSpec *v1alpha1.VolumeSplitSpec) (split *v1alpha1.VolumeSplit, err error) {
+	splitName := s.makeSplitName(volumeId)
+
+	split = &v1alpha1.VolumeSplit{
+		ObjectMeta: metav1.ObjectMeta{Name: splitName},
+		Spec:       *splitSpec,
+	}
+
+	split, err = s.unionClient.UnionV1alpha1().VolumeSplits().Create(ctx, split, metav1.CreateOptions{})
+	if err != nil {
+		klog.Infof("Error restoring VolumeSplit %q: %v", splitName, err)
+		return nil, err
+	}
+	klog.Infof("Restored VolumeSplit %q for volume %q", splitName, volumeId)
+	return split, nil
 }
 
 func (s *splitter) DeleteSplit(ctx context.Context, volumeId string) (err error) {
@@ -199,6 +512,22 @@ func (s *splitter) GetSplit(ctx context.Context, volumeId string) (split *v1alph
 	return
 }
 
+func (s *splitter) ListSplits(ctx context.Context) ([]v1alpha1.VolumeSplit, error) {
+	splits, err := s.unionClient.UnionV1alpha1().VolumeSplits().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return splits.Items, nil
+}
+
+func (s *splitter) UpdateSplitStatus(ctx context.Context, split *v1alpha1.VolumeSplit) (*v1alpha1.VolumeSplit, error) {
+	split, err := s.unionClient.UnionV1alpha1().VolumeSplits().UpdateStatus(ctx, split, metav1.UpdateOptions{})
+	if err != nil && apierrors.IsNotFound(err) {
+		err = fmt.Errorf("%w: %w", ErrVolumeNotFound, err)
+	}
+	return split, err
+}
+
 func (s *splitter) makeSplitName(volumeId string) string {
 	return volumeId + "-split"
 }
@@ -217,3 +546,15 @@ func WithClaimNamePrefix(prefix string) SplitterOption {
 		s.claimNamePrefix = prefix
 	}
 }
+
+func WithSplitRecorder(recorder *volumeRecorder) SplitterOption {
+	return func(s *splitter) {
+		s.recorder = recorder
+	}
+}
+
+func WithCapacityLister(lister storagelisters.CSIStorageCapacityLister) SplitterOption {
+	return func(s *splitter) {
+		s.capacityLister = lister
+	}
+}
@@ -0,0 +1,305 @@
+package union
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	cache "k8s.io/client-go/tools/cache"
+
+	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
+)
+
+func TestFitValue(t *testing.T) {
+	tests := []struct {
+		name              string
+		value             int64
+		capacity          int64
+		maximumVolumeSize int64
+		want              []int64
+	}{
+		{"fits one volume", 300, 400, 400, []int64{300}},
+		{"fits exactly", 400, 400, 400, []int64{400}},
+		{"split by maximum volume size", 300, 400, 120, []int64{100, 100, 100}},
+		{"split with remainder", 301, 400, 150, []int64{101, 100, 100}},
+		{"exceeds capacity", 500, 400, 400, nil},
+		{"no maximum volume size", 100, 400, 0, nil},
+		{"zero value", 0, 400, 400, []int64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fitValue(tt.value, tt.capacity, tt.maximumVolumeSize); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fitValue(%d, %d, %d) = %v, want %v", tt.value, tt.capacity, tt.maximumVolumeSize, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitQuantity(t *testing.T) {
+	zoneA := &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}}
+	zoneB := &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "b"}}
+
+	tests := []struct {
+		name             string
+		value            int64
+		storageClassName *string
+		capacities       []*storagev1.CSIStorageCapacity
+		want             []int64
+		wantTopology     *metav1.LabelSelector
+		wantErr          error
+	}{
+		{
+			name:             "no capacities splits evenly",
+			value:            301,
+			storageClassName: stringPtr("lower"),
+			want:             []int64{151, 150},
+		},
+		{
+			name:  "default storage class splits evenly",
+			value: 300,
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 100, 0),
+			},
+			want: []int64{150, 150},
+		},
+		{
+			name:             "single segment fits in one claim",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 400, 0),
+			},
+			want:         []int64{300},
+			wantTopology: zoneA,
+		},
+		{
+			name:             "single segment without topology",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", nil, 400, 0),
+			},
+			want: []int64{300},
+		},
+		{
+			name:             "maximum volume size splits in one segment",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 400, 150),
+			},
+			want:         []int64{150, 150},
+			wantTopology: zoneA,
+		},
+		{
+			name:             "largest segment that fits",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 350, 200),
+				makeCapacity("b", "lower", zoneB, 500, 100),
+			},
+			want:         []int64{100, 100, 100},
+			wantTopology: zoneB,
+		},
+		{
+			name:             "segments are not combined",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 200, 0),
+				makeCapacity("b", "lower", zoneB, 200, 0),
+			},
+			wantErr: ErrInsufficientCapacity,
+		},
+		{
+			name:             "unequal claims across segments",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", nil, 150, 0),
+				makeCapacity("b", "lower", nil, 180, 0),
+			},
+			want: []int64{180, 120},
+		},
+		{
+			name:             "segments of overlapping topologies are combined",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 200, 0),
+				makeCapacity("any", "lower", nil, 120, 0),
+			},
+			want:         []int64{200, 100},
+			wantTopology: zoneA,
+		},
+		{
+			name:             "segments combined with maximum volume size",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", nil, 200, 100),
+				makeCapacity("b", "lower", nil, 150, 0),
+			},
+			want: []int64{100, 100, 100},
+		},
+		{
+			name:             "combined segments too small",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", nil, 150, 0),
+				makeCapacity("b", "lower", nil, 100, 0),
+			},
+			wantErr: ErrInsufficientCapacity,
+		},
+		{
+			name:             "other storage classes are ignored",
+			value:            300,
+			storageClassName: stringPtr("lower"),
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "other", zoneA, 400, 0),
+			},
+			want: []int64{150, 150},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSplitter(t, tt.capacities)
+
+			quantities, topology, err := s.splitQuantity(resource.NewQuantity(tt.value, resource.BinarySI), tt.storageClassName)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("splitQuantity() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitQuantity() unexpected error: %v", err)
+			}
+
+			got := make([]int64, len(quantities))
+			for i, q := range quantities {
+				got[i] = q.Value()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitQuantity() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(topology, tt.wantTopology) {
+				t.Errorf("splitQuantity() topology = %v, want %v", topology, tt.wantTopology)
+			}
+		})
+	}
+}
+
+func TestFitExpansion(t *testing.T) {
+	zoneA := &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}}
+	zoneB := &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "b"}}
+
+	tests := []struct {
+		name       string
+		extra      int64
+		topology   *metav1.LabelSelector
+		capacities []*storagev1.CSIStorageCapacity
+		want       []int64
+		wantErr    error
+	}{
+		{
+			name:  "no topology adds one claim",
+			extra: 300,
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 100, 0),
+			},
+			want: []int64{300},
+		},
+		{
+			name:     "fits the segment of the volume",
+			extra:    300,
+			topology: zoneA,
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 400, 150),
+				makeCapacity("b", "lower", zoneB, 1000, 0),
+			},
+			want: []int64{150, 150},
+		},
+		{
+			name:     "unequal claims across segments of the volume",
+			extra:    300,
+			topology: zoneA,
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 180, 0),
+				makeCapacity("any", "lower", nil, 150, 0),
+				makeCapacity("b", "lower", zoneB, 1000, 0),
+			},
+			want: []int64{180, 120},
+		},
+		{
+			name:     "other segments are not used",
+			extra:    300,
+			topology: zoneA,
+			capacities: []*storagev1.CSIStorageCapacity{
+				makeCapacity("a", "lower", zoneA, 200, 0),
+				makeCapacity("b", "lower", zoneB, 1000, 0),
+			},
+			wantErr: ErrInsufficientCapacity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSplitter(t, tt.capacities)
+			split := &v1alpha1.VolumeSplit{Spec: v1alpha1.VolumeSplitSpec{VolumeName: "vol", StorageClassName: stringPtr("lower"), NodeTopology: tt.topology}}
+
+			quantities, err := s.fitExpansion(resource.NewQuantity(tt.extra, resource.BinarySI), split)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("fitExpansion() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fitExpansion() unexpected error: %v", err)
+			}
+
+			got := make([]int64, len(quantities))
+			for i, q := range quantities {
+				got[i] = q.Value()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fitExpansion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestSplitter returns a splitter that lists capacities as the CSIStorageCapacity objects of the cluster.
+func newTestSplitter(t *testing.T, capacities []*storagev1.CSIStorageCapacity) *splitter {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, c := range capacities {
+		if err := indexer.Add(c); err != nil {
+			t.Fatalf("failed to add capacity: %v", err)
+		}
+	}
+	return NewSplitter(nil, WithCapacityLister(storagelisters.NewCSIStorageCapacityLister(indexer)))
+}
+
+func makeCapacity(name, storageClassName string, topology *metav1.LabelSelector, capacity, maximumVolumeSize int64) *storagev1.CSIStorageCapacity {
+	c := &storagev1.CSIStorageCapacity{
+		ObjectMeta:       metav1.ObjectMeta{Name: name, Namespace: "lower-driver"},
+		StorageClassName: storageClassName,
+		NodeTopology:     topology,
+		Capacity:         resource.NewQuantity(capacity, resource.BinarySI),
+	}
+	if maximumVolumeSize > 0 {
+		c.MaximumVolumeSize = resource.NewQuantity(maximumVolumeSize, resource.BinarySI)
+	}
+	return c
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
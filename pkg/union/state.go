@@ -0,0 +1,56 @@
+package union
+
+import (
+	"path/filepath"
+	"time"
+)
+
+/*
+	The driver keeps what it needs on the nodes under a state directory, /var/lib/union-csi-driver.union.io by default,
+	set with --state-dir on both the controller and node plugins. The controller passes its state directory to the
+	node plugin in the publish context, so the two agree on where the union mount of a volume is served:
+
+	<state-dir>/volumes/<volume-id>/merged        the union mount of the volume served by its attach pod on the host
+	<state-dir>/volumes/<volume-id>/volume.json   the metadata of the volume, written by the node plugin at stage
+	<state-dir>/staged/                           the volumes staged on the node, for the node plugin to remount them
+*/
+
+const (
+	// DefaultStateDir is the default state directory of the driver on the nodes.
+	DefaultStateDir = "/var/lib/union-csi-driver.union.io"
+
+	volumesDirName     = "volumes"
+	mergedDirName      = "merged"
+	volumeMetadataName = "volume.json"
+)
+
+// VolumeMetadata describes the volume a directory under <state-dir>/volumes belongs to,
+// so that it can be told apart without asking the API server.
+type VolumeMetadata struct {
+	VolumeId      string        `json:"volumeId"`
+	AttachBackend AttachBackend `json:"attachBackend"`
+	// Branches are the lower PersistentVolumeClaims of the volume as <namespace>/<name>
+	Branches []string `json:"branches"`
+	// CreationTime is when the volume was first staged on the node
+	CreationTime time.Time `json:"creationTime"`
+}
+
+// MakeVolumesDir returns <stateDir>/volumes
+func MakeVolumesDir(stateDir string) string {
+	return filepath.Join(stateDir, volumesDirName)
+}
+
+// MakeVolumeDir returns <stateDir>/volumes/<volumeId>
+func MakeVolumeDir(stateDir, volumeId string) string {
+	return filepath.Join(MakeVolumesDir(stateDir), volumeId)
+}
+
+// MakeMergedDir returns <stateDir>/volumes/<volumeId>/merged
+func MakeMergedDir(stateDir, volumeId string) string {
+	return filepath.Join(MakeVolumeDir(stateDir, volumeId), mergedDirName)
+}
+
+// MakeVolumeMetadataPath returns <stateDir>/volumes/<volumeId>/volume.json
+func MakeVolumeMetadataPath(stateDir, volumeId string) string {
+	return filepath.Join(MakeVolumeDir(stateDir, volumeId), volumeMetadataName)
+}
@@ -6,20 +6,46 @@ import (
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type Interface interface {
 	CreateLower(ctx context.Context, volumeName string, options *CreateLowerOptions) (*Volume, error)
 	DeleteLower(ctx context.Context, volumeId string) error
+	ExpandLower(ctx context.Context, volumeId string, capacityBytes int64) (*Volume, error)
+	GetLowerCapacity(ctx context.Context, volumeId string) (int64, error)
 	AttachLower(ctx context.Context, volumeId, nodeId string) (*VolumeAttachment, error)
 	DetachLower(ctx context.Context, volumeId, nodeId string) error
+	GetLowerBranches(ctx context.Context, volumeId, nodeId string) ([]*Branch, error)
+	GetVolume(ctx context.Context, volumeId string) (*Volume, error)
+	ListAttachedVolumes(ctx context.Context, nodeId string) ([]string, error)
+	IsAttachedLower(ctx context.Context, volumeId, nodeId string) (bool, error)
 }
 
+// AttachBackend selects the Attacher implementation of a volume.
+type AttachBackend string
+
+const (
+	// AttachBackendPod merges the lower volumes in an attach pod on the node.
+	AttachBackendPod AttachBackend = "pod"
+	// AttachBackendNode has kubelet mount the lower volumes on the node for holder pods
+	// and the node plugin merge them directly on the host.
+	AttachBackendNode AttachBackend = "node"
+	// AttachBackendDaemon is AttachBackendNode with the lower volumes merged by the
+	// gogomergerfs daemon running on the node instead of the node plugin.
+	AttachBackendDaemon AttachBackend = "daemon"
+)
+
 type CreateLowerOptions struct {
 	CapacityBytes         int64
 	LowerNamespace        string
 	LowerStorageClassName *string
 	CSIAccessModes        []csi.VolumeCapability_AccessMode_Mode
+	AttachBackend         AttachBackend
+	AttachPodTemplate     string
+	// The "upper" PersistentVolumeClaim, if known
+	UpperClaimName      string
+	UpperClaimNamespace string
 }
 
 // TODO: integrate in AttachLower() args
@@ -37,35 +63,79 @@ type Volume struct {
 	CapacityBytes int64
 	//
 	AccessModes []v1.PersistentVolumeAccessMode
+	// CSIAccessModes are the CSI access modes the volume was created with, empty for the single-node volumes of the baseline
+	CSIAccessModes []csi.VolumeCapability_AccessMode_Mode
 	//
 	Namespace string
 	//
 	ClaimNames []string
 	//
 	StorageClassName *string
+	//
+	AttachBackend AttachBackend
+	// AttachPodTemplate is the name of the attach pod template of the pod attach backend, empty for the default
+	AttachPodTemplate string
+	// ClaimRef is the "upper" PersistentVolumeClaim, if known, for Events to be recorded on
+	ClaimRef *v1.ObjectReference
+	// SplitRef is the VolumeSplit of the volume, for Events to be recorded on
+	SplitRef *v1.ObjectReference
+	// NodeTopology selects the nodes the lower volumes can be attached at, nil for any node
+	NodeTopology *metav1.LabelSelector
+	// Generation is the generation of the VolumeSplit of the volume, recorded in the markers of its branches
+	Generation int64
 }
 
 type VolumeAttachment struct {
 	VolumeId string
 	NodeId   string
+	// HostPath is where the union mount is served on the node, if the backend serves one.
 	HostPath string
+	// PodPath is where the union mount is served inside an unprivileged attach pod,
+	// for the node plugin to pick it up from the mount namespace of the pod.
+	PodPath string
+}
+
+// Branch is a lower volume to be merged on a node by the node plugin,
+// as published by kubelet for the holder pod of its lower claim on that node.
+type Branch struct {
+	ClaimName string `json:"claimName"`
+	// VolumeName is the name of the PersistentVolume bound to the lower claim
+	VolumeName string `json:"volumeName"`
+	// PodUID is the UID of the holder pod kubelet published the lower volume for
+	PodUID string `json:"podUID,omitempty"`
 }
 
 // Attacher defines the interface that abstracts over the attach/detach operations
 type Attacher interface {
 	Attach(ctx context.Context, volume *Volume, nodeId string) (*VolumeAttachment, error)
 	Detach(ctx context.Context, volume *Volume, nodeId string) error
+	// IsAttached checks what the attach backend has at the node, regardless of the attachment records.
+	IsAttached(ctx context.Context, volume *Volume, nodeId string) (bool, error)
 }
 
 // NewVolumeFromVolumeSplit creates a Volume from a v1alpha1.VolumeSplit
 func NewVolumeFromVolumeSplit(split *v1alpha1.VolumeSplit) *Volume {
 	size := split.Spec.CapacityTotal[v1.ResourceStorage]
 	volume := &Volume{
-		VolumeId:         split.Spec.VolumeName,
-		CapacityBytes:    size.Value(),
-		AccessModes:      split.Spec.AccessModes,
-		Namespace:        split.Spec.Namespace,
-		StorageClassName: split.Spec.StorageClassName,
+		VolumeId:          split.Spec.VolumeName,
+		CapacityBytes:     size.Value(),
+		AccessModes:       split.Spec.AccessModes,
+		Namespace:         split.Spec.Namespace,
+		StorageClassName:  split.Spec.StorageClassName,
+		AttachBackend:     AttachBackend(split.Spec.AttachBackend),
+		AttachPodTemplate: split.Spec.AttachPodTemplate,
+		ClaimRef:          split.Spec.ClaimRef,
+		NodeTopology:      split.Spec.NodeTopology,
+		SplitRef:          makeSplitRef(split),
+		Generation:        split.Generation,
+	}
+	if volume.AttachBackend == "" {
+		volume.AttachBackend = AttachBackendPod
+	}
+	for _, mode := range split.Spec.CSIAccessModes {
+		if v, ok := csi.VolumeCapability_AccessMode_Mode_value[mode]; ok {
+			volume.CSIAccessModes = append(volume.CSIAccessModes, csi.VolumeCapability_AccessMode_Mode(v))
+		}
 	}
 	for i := range split.Spec.Splits {
 		volume.ClaimNames = append(volume.ClaimNames, split.Spec.Splits[i].ClaimName)
@@ -2,18 +2,37 @@ package union
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	wait "k8s.io/apimachinery/pkg/util/wait"
 	coreinformers "k8s.io/client-go/informers/core/v1"
+	storageinformers "k8s.io/client-go/informers/storage/v1"
 	kubernetes "k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	cache "k8s.io/client-go/tools/cache"
 	klog "k8s.io/klog/v2"
 
 	v1alpha1 "github.com/on2e/union-csi-driver/pkg/k8s/apis/union/v1alpha1"
 	unionclientset "github.com/on2e/union-csi-driver/pkg/k8s/client/clientset"
+	pod "github.com/on2e/union-csi-driver/pkg/union/pod"
+)
+
+// Defaults of the union options
+var (
+	// Semi-random values
+	DefaultBackoff = wait.Backoff{
+		Duration: 1 * time.Second,
+		Factor:   1.5,
+		Steps:    12,
+	}
+	DefaultOperationTimeout = 5 * time.Minute
+	DefaultOperationWait    = 10 * time.Second
 )
 
 type union struct {
@@ -23,7 +42,34 @@ type union struct {
 	nodeLister  corelisters.NodeLister
 
 	splitter Splitter
-	attacher Attacher
+	// attachers holds an Attacher implementation per backend.
+	attachers    map[AttachBackend]Attacher
+	nodeAttacher *nodeAttacher
+	podFactory   *pod.Factory
+	recorder     *volumeRecorder
+
+	// podLister and podWaiters are used for the marker reader pods of split recovery
+	podLister  corelisters.PodLister
+	podWaiters *podWaiters
+	backoff    wait.Backoff
+
+	claimInformer cache.SharedIndexInformer
+	podInformer   cache.SharedIndexInformer
+
+	// operations runs attach and detach operations in the background
+	operations *operationTracker
+
+	// splitRecovery recovers lost VolumeSplits from their lower claims on Run
+	splitRecovery bool
+}
+
+type unionOptions struct {
+	attachPodTemplates *pod.Templates
+	backoff            wait.Backoff
+	operationTimeout   time.Duration
+	operationWait      time.Duration
+	stateDir           string
+	splitRecovery      bool
 }
 
 func New(
@@ -31,21 +77,147 @@ func New(
 	unionClient unionclientset.Interface,
 	claimInformer coreinformers.PersistentVolumeClaimInformer,
 	nodeInformer coreinformers.NodeInformer,
-	podInformer coreinformers.PodInformer) *union {
+	podInformer coreinformers.PodInformer,
+	capacityInformer storageinformers.CSIStorageCapacityInformer,
+	options ...Option) *union {
+
+	unionOptions := &unionOptions{
+		attachPodTemplates: pod.NewTemplates(),
+		backoff:            DefaultBackoff,
+		operationTimeout:   DefaultOperationTimeout,
+		operationWait:      DefaultOperationWait,
+		stateDir:           DefaultStateDir,
+	}
+
+	for _, o := range options {
+		o(unionOptions)
+	}
+
+	recorder := newVolumeRecorder(kubeClient, claimInformer.Lister())
 
 	u := union{
-		kubeClient:  kubeClient,
-		claimLister: claimInformer.Lister(),
-		nodeLister:  nodeInformer.Lister(),
-		splitter:    NewSplitter(unionClient),
-		attacher:    NewAttacher(kubeClient, podInformer.Lister()),
+		kubeClient:    kubeClient,
+		claimLister:   claimInformer.Lister(),
+		nodeLister:    nodeInformer.Lister(),
+		splitter:      NewSplitter(unionClient, WithCapacityLister(capacityInformer.Lister()), WithSplitRecorder(recorder)),
+		podFactory:    pod.NewFactory(unionOptions.attachPodTemplates),
+		recorder:      recorder,
+		podLister:     podInformer.Lister(),
+		podWaiters:    newPodWaiters(podInformer),
+		backoff:       unionOptions.backoff,
+		claimInformer: claimInformer.Informer(),
+		podInformer:   podInformer.Informer(),
+		operations:    newOperationTracker(unionOptions.operationTimeout, unionOptions.operationWait),
+		splitRecovery: unionOptions.splitRecovery,
+	}
+
+	u.nodeAttacher = NewNodeAttacher(kubeClient, claimInformer.Lister(), podInformer, u.podFactory, unionOptions.backoff)
+	u.attachers = map[AttachBackend]Attacher{
+		AttachBackendPod:    NewAttacher(kubeClient, podInformer, u.podFactory, u.recorder, unionOptions.backoff, unionOptions.stateDir),
+		AttachBackendNode:   u.nodeAttacher,
+		AttachBackendDaemon: NewDaemonAttacher(u.nodeAttacher, podInformer.Lister()),
 	}
 
 	return &u
 }
 
+// Run watches lower claims for the Events of their binding and attach pods to attach their volumes again
+// if they fail or are deleted, and, if enabled, recovers lost VolumeSplits. It is meant for the controller only.
 func (u *union) Run(ctx context.Context) {
-	// NOOP
+	_, err := u.claimInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: u.onClaimUpdate,
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch lower claims: %v", err)
+	}
+	_, err = u.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: u.onAttachPodUpdate,
+		DeleteFunc: u.onAttachPodDelete,
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch attach pods: %v", err)
+	}
+	if u.splitRecovery {
+		u.recoverSplits(ctx)
+	}
+}
+
+// onClaimUpdate records an Event when a lower claim gets bound.
+func (u *union) onClaimUpdate(oldObj, newObj interface{}) {
+	oldClaim, ok := oldObj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+	newClaim, ok := newObj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+
+	volumeId, ok := newClaim.Labels[VolumeLabelKey]
+	if !ok || oldClaim.Status.Phase == v1.ClaimBound || newClaim.Status.Phase != v1.ClaimBound {
+		return
+	}
+
+	split, err := u.splitter.GetSplit(context.Background(), volumeId)
+	if err != nil {
+		klog.V(4).Infof("Failed to get VolumeSplit of lower claim %q to record event: %v", claimToClaimKey(newClaim), err)
+		return
+	}
+	u.recorder.SplitEventf(split, v1.EventTypeNormal, LowerClaimBoundEventReason, "Lower claim %q is bound to PersistentVolume %q", claimToClaimKey(newClaim), newClaim.Spec.VolumeName)
+}
+
+// onAttachPodUpdate attaches the volume of an attach pod again when the pod fails, e.g. is evicted,
+// since a failed attach pod never serves the union mount again.
+func (u *union) onAttachPodUpdate(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := newObj.(*v1.Pod)
+	if !ok || isPodTerminating(oldPod) || !isPodTerminating(newPod) || newPod.DeletionTimestamp != nil {
+		return
+	}
+	if volumeId, ok := newPod.Labels[VolumeLabelKey]; ok && newPod.Spec.NodeName != "" {
+		go u.reattachLower(volumeId, newPod.Spec.NodeName)
+	}
+}
+
+// onAttachPodDelete attaches the volume of an attach pod again when the pod is deleted by anyone but DetachLower.
+func (u *union) onAttachPodDelete(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if pod, ok = tombstone.Obj.(*v1.Pod); !ok {
+			return
+		}
+	}
+	if volumeId, ok := pod.Labels[VolumeLabelKey]; ok && pod.Spec.NodeName != "" {
+		go u.reattachLower(volumeId, pod.Spec.NodeName)
+	}
+}
+
+// reattachLower attaches volumeId at nodeId again if its attachment there is still desired,
+// for the node plugin to remount the volume from the new attach pod.
+func (u *union) reattachLower(volumeId, nodeId string) {
+	ctx := context.Background()
+
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		klog.V(4).Infof("Failed to get VolumeSplit of volume %q to attach it again: %v", volumeId, err)
+		return
+	}
+	i := findAttachment(split, nodeId)
+	if i < 0 || split.Status.Attachments[i].DesiredState != v1alpha1.AttachmentStateAttached {
+		return
+	}
+
+	klog.Infof("Attach pod of volume %q at node %q failed or was deleted, attaching the volume again", volumeId, nodeId)
+	if _, err := u.AttachLower(ctx, volumeId, nodeId); err != nil && !errors.Is(err, ErrOperationPending) {
+		klog.Errorf("Failed to attach volume %q at node %q again: %v", volumeId, nodeId, err)
+	}
 }
 
 func (u *union) CreateLower(ctx context.Context, volumeName string, options *CreateLowerOptions) (*Volume, error) {
@@ -54,12 +226,33 @@ func (u *union) CreateLower(ctx context.Context, volumeName string, options *Cre
 		return nil, err
 	}
 
+	if options.AttachPodTemplate != "" {
+		if options.AttachBackend != AttachBackendPod {
+			return nil, fmt.Errorf("%w: attach pod template %q is set but attach backend is %q", ErrInvalidParameter, options.AttachPodTemplate, options.AttachBackend)
+		}
+		if !u.podFactory.HasTemplate(options.AttachPodTemplate) {
+			return nil, fmt.Errorf("%w: attach pod template %q not found", ErrInvalidParameter, options.AttachPodTemplate)
+		}
+	}
+
 	splitSpec := &v1alpha1.VolumeSplitSpec{
-		VolumeName:       volumeName,
-		CapacityTotal:    v1.ResourceList{v1.ResourceStorage: *getQuantity(options.CapacityBytes)},
-		AccessModes:      accessModes,
-		Namespace:        options.LowerNamespace,
-		StorageClassName: options.LowerStorageClassName,
+		VolumeName:        volumeName,
+		CapacityTotal:     v1.ResourceList{v1.ResourceStorage: *getQuantity(options.CapacityBytes)},
+		AccessModes:       accessModes,
+		CSIAccessModes:    getCSIAccessModeNames(options.CSIAccessModes),
+		Namespace:         options.LowerNamespace,
+		StorageClassName:  options.LowerStorageClassName,
+		AttachBackend:     string(options.AttachBackend),
+		AttachPodTemplate: options.AttachPodTemplate,
+	}
+
+	if options.UpperClaimName != "" && options.UpperClaimNamespace != "" {
+		splitSpec.ClaimRef = &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Name:       options.UpperClaimName,
+			Namespace:  options.UpperClaimNamespace,
+		}
 	}
 
 	split, err := u.splitter.CreateSplit(ctx, volumeName, splitSpec)
@@ -103,22 +296,28 @@ func (u *union) createLowerFromSplit(ctx context.Context, split *v1alpha1.Volume
 
 	for i := range split.Spec.Splits {
 		// Consider adding concurrency here.
-		claim, newlyCreated, err := u.createLowerClaimFromSplit(ctx, split, &split.Spec.Splits[i])
+		claim, newlyCreated, err := u.createLowerClaimFromSplit(ctx, split, i)
 		if err != nil {
+			u.recorder.SplitEventf(split, v1.EventTypeWarning, ProvisioningFailedEventReason, "Failed to create lower claim \"%s/%s\": %v", split.Spec.Namespace, split.Spec.Splits[i].ClaimName, err)
 			return err
 		}
 		created++
 		if newlyCreated {
 			klog.Infof("Created lower claim %q (%d/%d)", claimToClaimKey(claim), created, total)
+			u.recorder.SplitEventf(split, v1.EventTypeNormal, LowerClaimCreatedEventReason, "Created lower claim %q (%d/%d)", claimToClaimKey(claim), created, total)
+		} else {
+			u.recorder.SplitEventf(split, v1.EventTypeNormal, LowerClaimAdoptedEventReason, "Adopted existing lower claim %q (%d/%d)", claimToClaimKey(claim), created, total)
 		}
 	}
 
 	return nil
 }
 
-func (u *union) createLowerClaimFromSplit(ctx context.Context, split *v1alpha1.VolumeSplit, claimSplit *v1alpha1.PersistentVolumeClaimSplit) (*v1.PersistentVolumeClaim, bool, error) {
+func (u *union) createLowerClaimFromSplit(ctx context.Context, split *v1alpha1.VolumeSplit, index int) (*v1.PersistentVolumeClaim, bool, error) {
 	var notFound bool
 
+	claimSplit := &split.Spec.Splits[index]
+
 	lowerClaim, err := u.getClaimLocal(split.Spec.Namespace, claimSplit.ClaimName)
 	if err != nil {
 		// First handle get errors other than IsNotFound that indicate a problem
@@ -129,9 +328,10 @@ func (u *union) createLowerClaimFromSplit(ctx context.Context, split *v1alpha1.V
 	if notFound {
 		lowerClaim = &v1.PersistentVolumeClaim{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      claimSplit.ClaimName,
-				Namespace: split.Spec.Namespace,
-				//Labels:    map[string]string{},
+				Name:        claimSplit.ClaimName,
+				Namespace:   split.Spec.Namespace,
+				Labels:      map[string]string{VolumeLabelKey: split.Spec.VolumeName},
+				Annotations: makeLowerClaimAnnotations(split, index),
 			},
 			Spec: v1.PersistentVolumeClaimSpec{
 				AccessModes:      split.Spec.AccessModes,
@@ -171,6 +371,7 @@ func (u *union) DeleteLower(ctx context.Context, volumeId string) error {
 	}
 
 	if err := u.splitter.DeleteSplit(ctx, volumeId); err != nil {
+		u.recorder.SplitEventf(split, v1.EventTypeWarning, CleanupFailedEventReason, "Failed to delete VolumeSplit %q: %v", split.Name, err)
 		return err
 	}
 
@@ -184,11 +385,13 @@ func (u *union) deleteLowerFromSplit(ctx context.Context, split *v1alpha1.Volume
 		claimSplit := &split.Spec.Splits[i]
 		newlyDeleted, err := u.deleteLowerClaimFromSplit(ctx, split, claimSplit)
 		if err != nil {
+			u.recorder.SplitEventf(split, v1.EventTypeWarning, CleanupFailedEventReason, "Failed to delete lower claim \"%s/%s\": %v", split.Spec.Namespace, claimSplit.ClaimName, err)
 			return err
 		}
 		deleted++
 		if newlyDeleted {
 			klog.Infof("Deleted lower claim \"%s/%s\" (%d/%d)", split.Spec.Namespace, claimSplit.ClaimName, deleted, total)
+			u.recorder.SplitEventf(split, v1.EventTypeNormal, LowerClaimDeletedEventReason, "Deleted lower claim \"%s/%s\" (%d/%d)", split.Spec.Namespace, claimSplit.ClaimName, deleted, total)
 		}
 	}
 
@@ -196,6 +399,10 @@ func (u *union) deleteLowerFromSplit(ctx context.Context, split *v1alpha1.Volume
 }
 
 func (u *union) deleteLowerClaimFromSplit(ctx context.Context, split *v1alpha1.VolumeSplit, claimSplit *v1alpha1.PersistentVolumeClaimSplit) (bool, error) {
+	// Let the claim past the admission webhook, which protects it while split exists.
+	if exists, err := u.unprotectLowerClaim(ctx, split.Spec.Namespace, claimSplit.ClaimName); err != nil || !exists {
+		return false, err
+	}
 	if err := u.kubeClient.CoreV1().PersistentVolumeClaims(split.Spec.Namespace).Delete(ctx, claimSplit.ClaimName, metav1.DeleteOptions{}); err != nil {
 		if !apierrors.IsNotFound(err) {
 			return false, err
@@ -205,11 +412,106 @@ func (u *union) deleteLowerClaimFromSplit(ctx context.Context, split *v1alpha1.V
 	return true, nil
 }
 
+// ExpandLower grows volumeId to capacityBytes with new lower claims, see Splitter.ExpandSplit.
+// The lower claims missing from a previous call are created as well, so ExpandLower can be retried.
+// The node plugin merges the new lower claims into the union mount on NodeExpandVolume, for the node and daemon
+// attach backends from the holder pods ExpandLower creates at the nodes the volume is attached at.
+// Volumes of the pod attach backend fail with ErrExpandPrecondition while attached, see NodeExpandVolume.
+func (u *union) ExpandLower(ctx context.Context, volumeId string, capacityBytes int64) (*Volume, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		return nil, err
+	}
+	if getAttachBackend(&split.Spec) == AttachBackendPod && len(split.Status.Attachments) > 0 {
+		capacityTotal := split.Spec.CapacityTotal[v1.ResourceStorage]
+		if getQuantity(capacityBytes).Cmp(capacityTotal) > 0 {
+			err := fmt.Errorf("%w: volume %q of the %q attach backend is attached at node %q, it can only be expanded while detached",
+				ErrExpandPrecondition, volumeId, AttachBackendPod, split.Status.Attachments[0].NodeName)
+			u.recorder.SplitEventf(split, v1.EventTypeWarning, ExpansionFailedEventReason, "Failed to expand volume to %d bytes: %v", capacityBytes, err)
+			return nil, err
+		}
+	}
+
+	split, err = u.splitter.ExpandSplit(ctx, volumeId, getQuantity(capacityBytes))
+	if err != nil {
+		if !errors.Is(err, ErrVolumeNotFound) {
+			if split, getErr := u.splitter.GetSplit(ctx, volumeId); getErr == nil {
+				u.recorder.SplitEventf(split, v1.EventTypeWarning, ExpansionFailedEventReason, "Failed to expand volume to %d bytes: %v", capacityBytes, err)
+			}
+		}
+		return nil, err
+	}
+
+	for i := range split.Spec.Splits {
+		if _, err := u.getClaimEscalate(ctx, split.Spec.Namespace, split.Spec.Splits[i].ClaimName); err == nil {
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		claim, newlyCreated, err := u.createLowerClaimFromSplit(ctx, split, i)
+		if err != nil {
+			u.recorder.SplitEventf(split, v1.EventTypeWarning, ExpansionFailedEventReason, "Failed to create lower claim \"%s/%s\": %v", split.Spec.Namespace, split.Spec.Splits[i].ClaimName, err)
+			return nil, err
+		}
+		if newlyCreated {
+			klog.Infof("Created lower claim %q of expanded volume %q", claimToClaimKey(claim), volumeId)
+			u.recorder.SplitEventf(split, v1.EventTypeNormal, LowerClaimCreatedEventReason, "Created lower claim %q (%d/%d)", claimToClaimKey(claim), i+1, len(split.Spec.Splits))
+		}
+	}
+
+	volume := NewVolumeFromVolumeSplit(split)
+
+	// Hold the new lower claims at the nodes the volume is attached at, for NodeExpandVolume to merge them.
+	if volume.AttachBackend == AttachBackendNode || volume.AttachBackend == AttachBackendDaemon {
+		for _, attachment := range split.Status.Attachments {
+			if attachment.DesiredState != v1alpha1.AttachmentStateAttached {
+				continue
+			}
+			if _, err := u.nodeAttacher.Attach(ctx, volume, attachment.NodeName); err != nil {
+				u.recorder.SplitEventf(split, v1.EventTypeWarning, ExpansionFailedEventReason, "Failed to attach new lower claims at node %q: %v", attachment.NodeName, err)
+				return nil, err
+			}
+		}
+	}
+
+	return volume, nil
+}
+
+// GetLowerCapacity returns the capacity of volumeId as the sum of the capacities of its bound lower claims,
+// which is what the union mount holds once all of them are merged. It returns ErrLowerClaimPending
+// if any lower claim is not bound yet, e.g. one just added by ExpandLower.
+func (u *union) GetLowerCapacity(ctx context.Context, volumeId string) (int64, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		return 0, err
+	}
+
+	capacity := resource.Quantity{}
+	for i := range split.Spec.Splits {
+		claim, err := u.getClaimEscalate(ctx, split.Spec.Namespace, split.Spec.Splits[i].ClaimName)
+		if err != nil {
+			return 0, err
+		}
+		size, ok := claim.Status.Capacity[v1.ResourceStorage]
+		if claim.Status.Phase != v1.ClaimBound || !ok {
+			return 0, fmt.Errorf("%w: lower claim %q of volume %q", ErrLowerClaimPending, claimToClaimKey(claim), volumeId)
+		}
+		capacity.Add(size)
+	}
+	return capacity.Value(), nil
+}
+
 // * Check that volume with volumeId exists: ErrNotFound -> codes.NotFound
 // * Check that node with nodeId exists: ErrNotFound -> codes.NotFound
 // * Check that volume is not attached on different node: -> codes.FailedPrecondition
 // * Idempotency: Check that volume is attached on node and is compatible with volume capability
 func (u *union) AttachLower(ctx context.Context, volumeId, nodeId string) (*VolumeAttachment, error) {
+	return u.operations.run(ctx, attachOperation, volumeId, nodeId, func(ctx context.Context) (*VolumeAttachment, error) {
+		return u.attachLower(ctx, volumeId, nodeId)
+	})
+}
+
+func (u *union) attachLower(ctx context.Context, volumeId, nodeId string) (*VolumeAttachment, error) {
 	split, err := u.splitter.GetSplit(ctx, volumeId)
 	if err != nil {
 		// klog
@@ -217,42 +519,238 @@ func (u *union) AttachLower(ctx context.Context, volumeId, nodeId string) (*Volu
 	}
 	volume := NewVolumeFromVolumeSplit(split)
 
-	if _, err := u.getNodeLocal(nodeId); err != nil {
+	node, err := u.getNodeLocal(nodeId)
+	if err != nil {
 		// klog
 		if apierrors.IsNotFound(err) {
 			err = fmt.Errorf("%w: %v", ErrNodeNotFound, err)
 		}
 		return nil, err
 	}
+	if err := checkNodeTopology(volume, node); err != nil {
+		u.recorder.Eventf(volume, v1.EventTypeWarning, AttachFailedEventReason, "Volume cannot be attached at node %q: %v", nodeId, err)
+		return nil, err
+	}
+
+	attacher, err := u.getAttacher(volume)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single-node volumes are attached at one node at a time, which the attachment records tell.
+	var inUseAt string
+	_, err = u.updateAttachment(ctx, volumeId, nodeId, func(split *v1alpha1.VolumeSplit, attachment *v1alpha1.VolumeSplitAttachment) error {
+		if !isMultiNodeVolume(volume) {
+			if other := findOtherAttachment(split, nodeId); other != nil {
+				inUseAt = other.NodeName
+				return ErrVolumeInUse
+			}
+		}
+		attachment.DesiredState = v1alpha1.AttachmentStateAttached
+		if attachment.ActualState != v1alpha1.AttachmentStateAttached {
+			attachment.ActualState = v1alpha1.AttachmentStateAttaching
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrVolumeInUse) {
+			u.recorder.Eventf(volume, v1.EventTypeWarning, AttachFailedEventReason, "Volume cannot be attached at node %q, already attached at node %q", nodeId, inUseAt)
+			return &VolumeAttachment{VolumeId: volumeId, NodeId: inUseAt}, err
+		}
+		return nil, fmt.Errorf("failed to record attachment: %w", err)
+	}
 
-	return u.attacher.Attach(ctx, volume, nodeId)
+	attachment, err := attacher.Attach(ctx, volume, nodeId)
+	if err != nil {
+		if errors.Is(err, ErrVolumeInUse) && attachment != nil {
+			u.recorder.Eventf(volume, v1.EventTypeWarning, AttachFailedEventReason, "Volume cannot be attached at node %q, already attached at node %q", nodeId, attachment.NodeId)
+		} else {
+			u.recorder.Eventf(volume, v1.EventTypeWarning, AttachFailedEventReason, "Failed to attach volume at node %q: %v", nodeId, err)
+		}
+		u.recordAttachmentError(ctx, volumeId, nodeId, err)
+		return attachment, err
+	}
+
+	_, err = u.updateAttachment(ctx, volumeId, nodeId, func(_ *v1alpha1.VolumeSplit, a *v1alpha1.VolumeSplitAttachment) error {
+		a.ActualState = v1alpha1.AttachmentStateAttached
+		a.HostPath = attachment.HostPath
+		a.PodPath = attachment.PodPath
+		a.Message = ""
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record attachment: %w", err)
+	}
+	u.recorder.Eventf(volume, v1.EventTypeNormal, AttachedEventReason, "Attached volume at node %q with the %q attach backend, lower claims %q", nodeId, volume.AttachBackend, volume.ClaimNames)
+
+	return attachment, nil
 }
 
-// 1. !attach-pod & volumeId   & nodeId: "volume not attached at node":   ErrAttachmentNotFound -> 0 OK
-// 2. !attach-pod & (!volumeId | !nodeId): "volume not attached at node": ErrAttachmentNotFound -> 0 OK
-// 3. attach-pod  & !volumeId  & *:                                       ErrVolumeNotFound -> 5 NOT_FOUND
-// 4. attach-pod  & *          & !nodeId:                                 ErrNodeNotFound -> 5 NOT_FOUND
+// The attachment record of volumeId at nodeId decides:
+// 1. !record  & volumeId   & nodeId: "volume not attached at node": ErrAttachmentNotFound -> 0 OK
+// 2. !record  & volumeId   & !nodeId: "volume not attached at node": ErrAttachmentNotFound -> 0 OK
+// 3. *        & !volumeId  & *:                                      ErrVolumeNotFound -> 5 NOT_FOUND
+// 4. record   & volumeId   & !nodeId:                                ErrNodeNotFound -> 5 NOT_FOUND
+// Without a record, the attach backend still cleans up what it has at the node, for attachments from before
+// the records or of a recovered VolumeSplit.
+// A record is removed only once the attach backend reports the volume detached.
 func (u *union) DetachLower(ctx context.Context, volumeId, nodeId string) error {
-	// VolumeSplit bears the namespace to be used to search for the attach pod,
-	// so there is no way now to check for "attachment" if "volume" is not found.
-	// This messes up the order in which we want to run the above checks.
+	_, err := u.operations.run(ctx, detachOperation, volumeId, nodeId, func(ctx context.Context) (*VolumeAttachment, error) {
+		return nil, u.detachLower(ctx, volumeId, nodeId)
+	})
+	return err
+}
+
+func (u *union) detachLower(ctx context.Context, volumeId, nodeId string) error {
+	// Records live in the VolumeSplit, there is no telling "attachment" if "volume" is not found.
 	split, err := u.splitter.GetSplit(ctx, volumeId)
 	if err != nil {
-		// klog
 		return err
 	}
 	volume := NewVolumeFromVolumeSplit(split)
 
-	// This is not in accordance with above checks. Settle with it for now.
+	if findAttachment(split, nodeId) < 0 {
+		klog.Infof("Volume %q has no attachment record at node %q, detaching whatever the attach backend has there", volumeId, nodeId)
+		return u.detachUnrecorded(ctx, volume, nodeId)
+	}
+
 	if _, err := u.getNodeLocal(nodeId); err != nil {
-		// klog
 		if apierrors.IsNotFound(err) {
 			err = fmt.Errorf("%w: %v", ErrNodeNotFound, err)
 		}
 		return err
 	}
 
-	return u.attacher.Detach(ctx, volume, nodeId)
+	attacher, err := u.getAttacher(volume)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.updateAttachment(ctx, volumeId, nodeId, func(_ *v1alpha1.VolumeSplit, a *v1alpha1.VolumeSplitAttachment) error {
+		a.DesiredState = v1alpha1.AttachmentStateDetached
+		if a.ActualState != v1alpha1.AttachmentStateDetached {
+			a.ActualState = v1alpha1.AttachmentStateDetaching
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record detachment: %w", err)
+	}
+
+	// ErrAttachmentNotFound here means the attach backend has nothing left at the node, i.e. the detachment is complete.
+	if err := attacher.Detach(ctx, volume, nodeId); err != nil && !errors.Is(err, ErrAttachmentNotFound) {
+		u.recorder.Eventf(volume, v1.EventTypeWarning, DetachFailedEventReason, "Failed to detach volume from node %q: %v", nodeId, err)
+		u.recordAttachmentError(ctx, volumeId, nodeId, err)
+		return err
+	}
+
+	_, err = u.updateAttachment(ctx, volumeId, nodeId, func(_ *v1alpha1.VolumeSplit, a *v1alpha1.VolumeSplitAttachment) error {
+		a.ActualState = v1alpha1.AttachmentStateDetached
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record detachment: %w", err)
+	}
+	u.recorder.Eventf(volume, v1.EventTypeNormal, DetachedEventReason, "Detached volume from node %q, lower claims %q", nodeId, volume.ClaimNames)
+
+	return nil
+}
+
+// detachUnrecorded detaches volume from node nodeId through the attach backend, without an attachment record.
+func (u *union) detachUnrecorded(ctx context.Context, volume *Volume, nodeId string) error {
+	attacher, err := u.getAttacher(volume)
+	if err != nil {
+		return err
+	}
+
+	if err := attacher.Detach(ctx, volume, nodeId); err != nil {
+		if errors.Is(err, ErrAttachmentNotFound) {
+			return ErrAttachmentNotFound
+		}
+		u.recorder.Eventf(volume, v1.EventTypeWarning, DetachFailedEventReason, "Failed to detach volume from node %q: %v", nodeId, err)
+		return err
+	}
+	u.recorder.Eventf(volume, v1.EventTypeNormal, DetachedEventReason, "Detached volume from node %q, lower claims %q", nodeId, volume.ClaimNames)
+
+	return nil
+}
+
+// recordAttachmentError keeps err as the message of the attachment of volumeId at nodeId.
+func (u *union) recordAttachmentError(ctx context.Context, volumeId, nodeId string, err error) {
+	_, updateErr := u.updateAttachment(ctx, volumeId, nodeId, func(_ *v1alpha1.VolumeSplit, a *v1alpha1.VolumeSplitAttachment) error {
+		a.Message = err.Error()
+		return nil
+	})
+	if updateErr != nil {
+		klog.Errorf("Failed to record error of attachment of volume %q at node %q: %v", volumeId, nodeId, updateErr)
+	}
+}
+
+// GetVolume returns volumeId as recorded in its VolumeSplit, e.g. for the node plugin to learn its generation.
+func (u *union) GetVolume(ctx context.Context, volumeId string) (*Volume, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		return nil, err
+	}
+	return NewVolumeFromVolumeSplit(split), nil
+}
+
+// ListAttachedVolumes returns the IDs of the volumes with an attachment at nodeId in any state,
+// going by the API server rather than any cache.
+func (u *union) ListAttachedVolumes(ctx context.Context, nodeId string) ([]string, error) {
+	splits, err := u.splitter.ListSplits(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeIds []string
+	for i := range splits {
+		if findAttachment(&splits[i], nodeId) >= 0 {
+			volumeIds = append(volumeIds, splits[i].Spec.VolumeName)
+		}
+	}
+	return volumeIds, nil
+}
+
+// IsAttachedLower checks if the attach backend of volumeId still has anything at nodeId, e.g. an attach pod,
+// whether or not the attachment is recorded. Volumes attached before the records or of recovered VolumeSplits have none.
+func (u *union) IsAttachedLower(ctx context.Context, volumeId, nodeId string) (bool, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		return false, err
+	}
+	volume := NewVolumeFromVolumeSplit(split)
+
+	attacher, err := u.getAttacher(volume)
+	if err != nil {
+		return false, err
+	}
+	return attacher.IsAttached(ctx, volume, nodeId)
+}
+
+// GetLowerBranches returns the lower volumes the node plugin has to mount and merge for volumes
+// of the node and daemon attach backends.
+func (u *union) GetLowerBranches(ctx context.Context, volumeId, nodeId string) ([]*Branch, error) {
+	split, err := u.splitter.GetSplit(ctx, volumeId)
+	if err != nil {
+		return nil, err
+	}
+	volume := NewVolumeFromVolumeSplit(split)
+
+	if volume.AttachBackend != AttachBackendNode && volume.AttachBackend != AttachBackendDaemon {
+		return nil, fmt.Errorf("volume %q has attach backend %q, lower branches are mounted by the %q and %q backends only", volumeId, volume.AttachBackend, AttachBackendNode, AttachBackendDaemon)
+	}
+
+	return u.nodeAttacher.GetBranches(ctx, volume, nodeId)
+}
+
+// getAttacher returns the Attacher of the attach backend of volume.
+func (u *union) getAttacher(volume *Volume) (Attacher, error) {
+	attacher, ok := u.attachers[volume.AttachBackend]
+	if !ok {
+		return nil, fmt.Errorf("unknown attach backend %q of volume %q", volume.AttachBackend, volume.VolumeId)
+	}
+	return attacher, nil
 }
 
 // getClaimLocal retrieves claim by namespace/name by looking in local cache.
@@ -284,3 +782,49 @@ func (u *union) getNodeEscalate(ctx context.Context, name string) (node *v1.Node
 	}
 	return
 }
+
+// Option is a functional option type for unionOptions
+type Option func(*unionOptions)
+
+// WithAttachPodTemplates sets the templates attach pods are created from.
+func WithAttachPodTemplates(templates *pod.Templates) Option {
+	return func(o *unionOptions) {
+		o.attachPodTemplates = templates
+	}
+}
+
+// WithBackoff sets the backoff of waiting for attach backends to attach and detach volumes.
+func WithBackoff(backoff wait.Backoff) Option {
+	return func(o *unionOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WithOperationTimeout sets how long attach and detach operations may take.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(o *unionOptions) {
+		o.operationTimeout = timeout
+	}
+}
+
+// WithOperationWait sets how long AttachLower and DetachLower wait for their operation to complete
+// before returning ErrOperationPending.
+func WithOperationWait(wait time.Duration) Option {
+	return func(o *unionOptions) {
+		o.operationWait = wait
+	}
+}
+
+// WithStateDir sets the state directory of the driver on the nodes, where attach pods serve union mounts.
+func WithStateDir(dir string) Option {
+	return func(o *unionOptions) {
+		o.stateDir = dir
+	}
+}
+
+// WithSplitRecovery sets whether Run recovers the VolumeSplits of volumes whose lower claims exist without one.
+func WithSplitRecovery(enabled bool) Option {
+	return func(o *unionOptions) {
+		o.splitRecovery = enabled
+	}
+}
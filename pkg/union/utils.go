@@ -6,6 +6,8 @@ import (
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	v1 "k8s.io/api/core/v1"
 	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
 )
 
 func getQuantity(value int64) *resource.Quantity {
@@ -44,6 +46,66 @@ func csiToK8sAccessMode(mode csi.VolumeCapability_AccessMode_Mode) (v1.Persisten
 	}
 }
 
+// getCSIAccessModeNames returns the names of modes, e.g. SINGLE_NODE_WRITER, to be recorded in a VolumeSplit.
+func getCSIAccessModeNames(modes []csi.VolumeCapability_AccessMode_Mode) []string {
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		names = append(names, mode.String())
+	}
+	return names
+}
+
+// getCSIAccessModeNamesFromVolume returns the names of the CSI access modes the provisioner asks for
+// the access modes of a PersistentVolume, e.g. to recover them from the PersistentVolume of a volume.
+func getCSIAccessModeNamesFromVolume(pv *v1.PersistentVolume) []string {
+	var modes []csi.VolumeCapability_AccessMode_Mode
+	for _, mode := range pv.Spec.AccessModes {
+		switch mode {
+		case v1.ReadWriteOnce:
+			modes = append(modes, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+		case v1.ReadWriteOncePod:
+			modes = append(modes, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER)
+		case v1.ReadOnlyMany:
+			modes = append(modes, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)
+		case v1.ReadWriteMany:
+			modes = append(modes, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)
+		}
+	}
+	return getCSIAccessModeNames(modes)
+}
+
+// isMultiNodeVolume checks if volume can be attached on more than one node at a time,
+// i.e. if it was created with a MULTI_NODE_... CSI access mode.
+// Volumes without CSI access modes are the ReadWriteOnce volumes of the baseline, which are single-node,
+// so their attach pods keep their names.
+func isMultiNodeVolume(volume *Volume) bool {
+	for _, mode := range volume.CSIAccessModes {
+		switch mode {
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			return true
+		}
+	}
+	return false
+}
+
+// checkNodeTopology checks that node is in the topology segment the lower claims of volume were sized for,
+// since they are provisioned where they are first attached. Returns ErrAttachPrecondition otherwise.
+func checkNodeTopology(volume *Volume, node *v1.Node) error {
+	if volume.NodeTopology == nil {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(volume.NodeTopology)
+	if err != nil {
+		return fmt.Errorf("invalid node topology of volume %q: %v", volume.VolumeId, err)
+	}
+	if !selector.Matches(labels.Set(node.Labels)) {
+		return fmt.Errorf("%w: node %q is not in the topology segment %q the lower claims were sized for", ErrAttachPrecondition, node.Name, selector)
+	}
+	return nil
+}
+
 func claimToClaimKey(claim *v1.PersistentVolumeClaim) string {
 	return fmt.Sprintf("%s/%s", claim.Namespace, claim.Name)
 }
@@ -0,0 +1,36 @@
+package union
+
+import (
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestIsMultiNodeVolume(t *testing.T) {
+	tests := []struct {
+		name           string
+		csiAccessModes []csi.VolumeCapability_AccessMode_Mode
+		want           bool
+	}{
+		{"single node writer", []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}, false},
+		{"single node reader only", []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY}, false},
+		{"multi node reader only", []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY}, true},
+		{"multi node multi writer", []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}, true},
+		{"single and multi node", []csi.VolumeCapability_AccessMode_Mode{
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessModes, err := getAccessModes(tt.csiAccessModes)
+			if err != nil {
+				t.Fatalf("getAccessModes() unexpected error: %v", err)
+			}
+			volume := &Volume{AccessModes: accessModes, CSIAccessModes: tt.csiAccessModes}
+			if got := isMultiNodeVolume(volume); got != tt.want {
+				t.Errorf("isMultiNodeVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	union "github.com/on2e/union-csi-driver/pkg/union"
+)
+
+const (
+	// ValidateLowerClaimsPath is the path the ValidatingWebhookConfiguration of lower claims calls.
+	ValidateLowerClaimsPath = "/validate-lower-claims"
+
+	DefaultAddress = ":9443"
+)
+
+// maxRequestBytes caps the AdmissionReviews read, the API server sends far less.
+const maxRequestBytes = 4 << 20
+
+// ClaimValidator validates deletes and updates of lower PersistentVolumeClaims, newClaim is nil for deletes.
+type ClaimValidator interface {
+	ValidateLowerClaimChange(ctx context.Context, oldClaim, newClaim *v1.PersistentVolumeClaim) error
+}
+
+// Server serves the validating admission webhook that protects lower claims from being deleted or changed.
+type Server struct {
+	validator ClaimValidator
+	options   *serverOptions
+}
+
+type serverOptions struct {
+	address  string
+	certFile string
+	keyFile  string
+}
+
+func NewServer(validator ClaimValidator, options ...Option) *Server {
+	serverOptions := &serverOptions{
+		address: DefaultAddress,
+	}
+
+	for _, o := range options {
+		o(serverOptions)
+	}
+
+	return &Server{
+		validator: validator,
+		options:   serverOptions,
+	}
+}
+
+// Run serves the webhook over TLS until ctx is done or serving fails.
+func (s *Server) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidateLowerClaimsPath, s.serveValidateLowerClaims)
+
+	srv := &http.Server{Addr: s.options.address, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	klog.Infof("Serving admission webhook at %q", s.options.address)
+	if err := srv.ListenAndServeTLS(s.options.certFile, s.options.keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) serveValidateLowerClaims(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = s.validateLowerClaim(r.Context(), review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		klog.Errorf("Failed to write AdmissionReview response: %v", err)
+	}
+}
+
+// validateLowerClaim admits the delete or update of a lower claim in request unless the validator refuses it.
+func (s *Server) validateLowerClaim(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	var oldClaim, newClaim *v1.PersistentVolumeClaim
+
+	switch request.Operation {
+	case admissionv1.Delete, admissionv1.Update:
+		oldClaim = &v1.PersistentVolumeClaim{}
+		if err := json.Unmarshal(request.OldObject.Raw, oldClaim); err != nil {
+			return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid old object: %v", err))
+		}
+		// The API server omits the namespace of the object in some versions.
+		if oldClaim.Namespace == "" {
+			oldClaim.Namespace = request.Namespace
+		}
+	default:
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	if request.Operation == admissionv1.Update {
+		newClaim = &v1.PersistentVolumeClaim{}
+		if err := json.Unmarshal(request.Object.Raw, newClaim); err != nil {
+			return deny(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid object: %v", err))
+		}
+	}
+
+	if err := s.validator.ValidateLowerClaimChange(ctx, oldClaim, newClaim); err != nil {
+		if errors.Is(err, union.ErrLowerClaimProtected) {
+			return deny(http.StatusForbidden, metav1.StatusReasonForbidden, err.Error())
+		}
+		klog.Errorf("Failed to validate %s of lower claim \"%s/%s\": %v", request.Operation, request.Namespace, request.Name, err)
+		return deny(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func deny(code int32, reason metav1.StatusReason, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
+
+// Option is a functional option type for serverOptions
+type Option func(*serverOptions)
+
+// WithAddress sets the address the webhook is served at.
+func WithAddress(address string) Option {
+	return func(o *serverOptions) {
+		o.address = address
+	}
+}
+
+// WithTLSFiles sets the certificate and key files the webhook is served with.
+func WithTLSFiles(certFile, keyFile string) Option {
+	return func(o *serverOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}